@@ -1,22 +1,26 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/fatih/color"
+	"github.com/fosskers/active/cache"
 	"github.com/fosskers/active/config"
 	"github.com/fosskers/active/gitutils"
 	"github.com/fosskers/active/parsing"
 	"github.com/fosskers/active/utils"
 	"github.com/go-git/go-git/v5"
-	"github.com/google/go-github/v31/github"
 )
 
 // Paths.
@@ -30,6 +34,13 @@ var autoF *bool = flag.Bool("y", false, "Automatically apply changes.")
 var configPathF *string = flag.String("config", confPath, "Path to config file.")
 var pushF *bool = flag.Bool("push", false, "Automatically make commits and open a PR on Github.")
 var nocolourF *bool = flag.Bool("nocolor", false, "Disable coloured output.")
+var pinF *bool = flag.Bool("pin", false, "Pin Actions to the commit SHA of their latest release.")
+var refreshF *bool = flag.Bool("refresh", false, "Ignore cached lookups and query Github afresh.")
+var jobsF *int = flag.Int("jobs", 8, "How many Github requests and git operations may run at once.")
+var timeoutF *time.Duration = flag.Duration("timeout", 10*time.Minute, "How long each stage (pulling, lookups, pushing) may take. 0 for no limit.")
+var manifestF *string = flag.String("manifest", "", "Read versions from this manifest file instead of looking them up.")
+var exportF *string = flag.String("export", "", "Look up versions and write them to this manifest file, without offering updates.")
+var requestTimeoutF *time.Duration = flag.Duration("request-timeout", 30*time.Second, "How long a single Github request may take. 0 for no limit.")
 
 // Coloured output.
 var cyan = color.New(color.FgCyan).SprintFunc()
@@ -39,16 +50,19 @@ var green = color.New(color.FgGreen).SprintFunc()
 type Project struct {
 	name      string
 	owner     string
+	host      string // Where the project's remote lives, like `github.com`.
 	remote    string
 	workflows []*Workflow
 	repo      *git.Repository
 	accepted  []string // Mutable field.
 	branch    string
+	skipped   []string // Why any steps of its workflows were ignored.
 }
 
 // All data pertaining to a fully read and parsed Workflow file.
 type Workflow struct {
 	path    string // Full filepath to the workflow file.
+	rel     string // Relative to the project, like `.github/workflows/ci.yaml`.
 	yaml    string
 	actions []parsing.Action
 }
@@ -61,13 +75,48 @@ func main() {
 		color.NoColor = true
 	}
 
-	if *pushF && *tokenF == "" && c.Git.Token == "" {
+	if *pinF {
+		c.Pin = true
+	}
+
+	if *jobsF < 1 {
+		utils.PrintExit("'--jobs' must be at least 1.")
+	}
+
+	if *manifestF != "" && *exportF != "" {
+		utils.PrintExit("'--manifest' and '--export' can't be used together.")
+	}
+
+	// Exported manifests should allow pinning offline.
+	if *exportF != "" {
+		c.Pin = true
+	}
+
+	if *pushF && c.TokenFor(c.MainHost(), *tokenF) == "" {
 		utils.PrintExit("A real token must be given when using '--push'.")
 	}
 
-	client := config.GithubClient(c, tokenF) // Github communication.
-	env := config.RuntimeEnv(c, client)      // Runtime environment.
-	projects := allProjects(c)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	onInterrupt(cancel)
+	gitutils.RequestTimeout = *requestTimeoutF
+
+	authed := c.TokenFor(c.MainHost(), *tokenF) != ""
+	lookups := cache.Open(c.Cache.Path, c.CacheTTL(), *refreshF) // Results of previous runs.
+	jobs := utils.NewPool(*jobsF)
+	env := config.RuntimeEnv(ctx, c, *tokenF, lookups, jobs, *timeoutF) // Runtime environment.
+	for _, host := range c.AllHosts() {
+		_, e1 := env.Forge(host) // Fail early on bad host settings.
+		utils.ExitIfErr(e1)
+	}
+	if *manifestF != "" {
+		manifest, e2 := gitutils.ReadStatic(*manifestF)
+		utils.ExitIfErr(e2)
+		env.Source = manifest // Nothing is looked up at all.
+		env.Images = manifest
+		env.GraphQL = nil
+	}
+	projects := allProjects(env)
 
 	// Report discovered files.
 	longest := 0
@@ -86,57 +135,104 @@ func main() {
 	}
 
 	// Register parsed Actions (calls the Github API).
-	var wg sync.WaitGroup
-	for _, proj := range projects {
-		for _, wf := range proj.workflows {
-			wg.Add(1)
-			go func(w *Workflow) {
-				register(env, w.actions)
-				wg.Done()
-			}(wf)
-		}
+	pins := pinnedRepos(c, projects)
+	lctx, done := env.Stage()
+	if env.GraphQL != nil {
+		prefetch(lctx, env, projects)
+	}
+	register(lctx, env, pins, projects)
+	done()
+	if e0 := lookups.Save(); e0 != nil {
+		fmt.Printf("Unable to save the lookup cache: %s\n", e0)
+	}
+	if *exportF != "" {
+		exportManifest(env, *exportF)
+		printFailures(env, authed)
+		return
 	}
-	wg.Wait()
 
-	// Perform updates concurrently.
+	// Offer updates one project at a time, in the order they were given.
 	for _, proj := range projects {
-		wg.Add(1)
-		go func(p *Project) {
-			applyUpdates(env, p)
-			wg.Done()
-		}(proj)
+		applyUpdates(env, proj)
 	}
-	wg.Wait()
 
 	// Commit and push updates to Github.
 	if *pushF {
-		for _, proj := range projects {
+		pctx, done := env.Stage()
+		defer done()
+		var wg sync.WaitGroup
+		results := make([]string, len(projects)) // Reported in project order.
+		for i, proj := range projects {
 			if len(proj.accepted) > 0 {
-				wg.Add(1)
-				go func(p *Project) {
-					defer wg.Done()
+				i, p := i, proj
+				env.Jobs.Go(&wg, func() {
 					defer gitutils.Checkout(p.repo, "master")
-					pr, e := commitAndPush(client, c, p)
+					pr, e := commitAndPush(pctx, env, p)
 					if e != nil {
-						fmt.Println(e)
+						results[i] = e.Error()
 						return
 					}
-					fmt.Printf("Successfully opened a PR for %s! (#%d)\n", cyan(p.name), pr)
-				}(proj)
+					results[i] = fmt.Sprintf("Successfully opened a PR for %s! (#%d)", cyan(p.name), pr)
+				})
 			}
 		}
 		wg.Wait()
+		for _, r := range results {
+			if r != "" {
+				fmt.Println(r)
+			}
+		}
 	}
 
+	printFailures(env, authed)
 	fmt.Println("Done.")
 }
 
+// A branch created by `switchBranches`.
+type newBranch struct {
+	project string
+	repo    *git.Repository
+	name    string
+}
+
+// Every branch created so far, so that they can be undone if `active` is
+// interrupted.
+var created = struct {
+	sync.Mutex
+	branches []newBranch
+}{}
+
+// On Ctrl-C, cancel everything in flight and put any projects whose branches
+// were switched back the way they were, then exit.
+func onInterrupt(cancel context.CancelFunc) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		cancel()
+		fmt.Println("\nInterrupted. Cleaning up...")
+		created.Lock()
+		for _, b := range created.branches {
+			if err := gitutils.Restore(b.repo, b.name); err != nil {
+				fmt.Printf("Unable to restore the branch of %s: %s\n", cyan(b.project), err)
+			}
+		}
+		created.Unlock()
+		os.Exit(130)
+	}()
+}
+
 // Will exit the program if there are no projects to check, or if a specified
-// project has no workflow files.
-func allProjects(c *config.Config) []*Project {
+// project has no workflow files. Projects are yielded, and any problems with
+// them reported, in the order that the config file lists them.
+func allProjects(env *config.Env) []*Project {
+	c := env.Conf
+	ctx, done := env.Stage()
+	defer done()
 	if *localF {
-		p, e0 := project(c, ".")
+		p, e0 := project(ctx, c, ".")
 		utils.ExitIfErr(e0) // Fail hard if the only project we're checking is invalid.
+		p.report()
 		return []*Project{p}
 	}
 
@@ -145,36 +241,39 @@ func allProjects(c *config.Config) []*Project {
 	}
 
 	var wg sync.WaitGroup
-	var mut sync.Mutex
-	ps := make([]*Project, 0)
-	for _, proj := range c.Projects {
-		wg.Add(1)
-		go func(p string) {
-			defer wg.Done()
-			proj, e0 := project(c, p)
-			if e0 != nil {
-				fmt.Println(e0)
-				return
-			}
-			mut.Lock()
-			ps = append(ps, proj)
-			mut.Unlock()
-		}(proj)
+	found := make([]*Project, len(c.Projects))
+	errs := make([]error, len(c.Projects))
+	for i, path := range c.Projects {
+		i, path := i, path
+		env.Jobs.Go(&wg, func() {
+			found[i], errs[i] = project(ctx, c, path)
+		})
 	}
 	wg.Wait()
+	ps := make([]*Project, 0, len(found))
+	for i, p := range found {
+		if errs[i] != nil {
+			fmt.Println(errs[i])
+			continue
+		}
+		p.report()
+		ps = append(ps, p)
+	}
 	return ps
 }
 
 // Given a local path to a Git repository, read everything from the filesystem
 // that's necessary for further processing.
 //
-// Exits the program if even one file fails to be read, or if there weren't any
-// to be read for the given project.
-func project(c *config.Config, path string) (*Project, error) {
+// Fails if even one file fails to be read, or if there weren't any to be read
+// for the given project. If a branch was already switched to by then, the
+// project is switched back.
+func project(ctx context.Context, c *config.Config, path string) (*Project, error) {
 	name := filepath.Base(path)
 
 	var repo *git.Repository
 	owner := ""
+	host := ""
 	remote := ""
 	branch := ""
 	if *pushF {
@@ -184,58 +283,106 @@ func project(c *config.Config, path string) (*Project, error) {
 		}
 		repo = r
 
-		rem, own, e1 := gitutils.PushableRemote(r)
+		rem, h, own, e1 := gitutils.PushableRemote(r)
 		if e1 != nil {
 			return nil, e1
 		}
 		remote = rem
+		host = h
 		owner = own
 
-		br, e2 := switchBranches(c, r, remote, name)
+		br, e2 := switchBranches(ctx, c, r, remote, host, name)
 		if e2 != nil {
 			return nil, e2
 		}
 		branch = br
 	}
 
-	// Read and parse all Workflow files.
-	wps, e1 := workflows(path)
-	utils.ExitIfErr(e1)
-	if len(wps) == 0 {
-		utils.PrintExit("No workflow files detected for " + name)
-	}
-	ws := make([]*Workflow, 0)
-	for _, wp := range wps {
-		yaml := readWorkflow(wp)
-		actions := parsing.Actions(yaml)
-		workflow := Workflow{wp, yaml, actions}
-		ws = append(ws, &workflow)
+	ws, skipped, e3 := readWorkflows(c, path)
+	if e3 != nil {
+		// Don't leave the project on a branch that will never be used.
+		if repo != nil {
+			if e4 := gitutils.Restore(repo, branch); e4 != nil {
+				return nil, fmt.Errorf("%s (and unable to restore the branch of %s: %s)", e3, cyan(name), e4)
+			}
+		}
+		return nil, e3
 	}
 
 	return &Project{
 		name:      name,
 		owner:     owner,
+		host:      host,
 		remote:    remote,
 		workflows: ws,
 		repo:      repo,
 		accepted:  make([]string, 0),
 		branch:    branch,
+		skipped:   skipped,
 	}, nil
 }
 
-// Given a local path to a code repository, find the paths of all its Github
-// workflow configuration files.
-func workflows(project string) ([]string, error) {
-	workflowDir := filepath.Join(project, ".github/workflows")
-	items, err := ioutil.ReadDir(workflowDir)
-	if err != nil {
-		return nil, err
+// Read and parse all Workflow files of a project. Steps that couldn't be
+// understood, or couldn't be pinned, are described in the second value.
+func readWorkflows(c *config.Config, path string) ([]*Workflow, []string, error) {
+	wps, e0 := workflows(path)
+	if e0 != nil {
+		return nil, nil, e0
 	}
-	fullPaths := make([]string, 0, len(items))
-	for _, file := range items {
-		if !file.IsDir() {
-			full := filepath.Join(workflowDir, file.Name())
-			fullPaths = append(fullPaths, full)
+	if len(wps) == 0 {
+		return nil, nil, fmt.Errorf("No workflow files detected for %s", filepath.Base(path))
+	}
+	ws := make([]*Workflow, 0)
+	skipped := make([]string, 0)
+	for _, wp := range wps {
+		yaml, e1 := readWorkflow(wp)
+		if e1 != nil {
+			return nil, nil, e1
+		}
+		parsed, e2 := parsing.ParseWorkflow(yaml)
+		if e2 != nil {
+			return nil, nil, fmt.Errorf("Unable to parse %s: %s", wp, e2)
+		}
+		actions, errs := parsing.Actions(parsed)
+		for _, e3 := range errs {
+			skipped = append(skipped, fmt.Sprintf("Ignoring a step in %s: %s", wp, e3))
+		}
+		for _, a := range actions {
+			if c.Pin && a.Crowded && !a.Pinned() && c.Manages(a.Repo()) {
+				skipped = append(skipped, fmt.Sprintf("Not pinning a step in %s: Line %d: %s shares its line with another step, so its version couldn't be noted.", wp, a.Line, a.Raw()))
+			}
+		}
+		rel, _ := filepath.Rel(path, wp)
+		workflow := Workflow{wp, rel, yaml, actions}
+		ws = append(ws, &workflow)
+	}
+	return ws, skipped, nil
+}
+
+// Report anything about the project that was passed over while reading it.
+func (p *Project) report() {
+	for _, s := range p.skipped {
+		fmt.Println(s)
+	}
+}
+
+// Given a local path to a code repository, find the paths of all its workflow
+// configuration files, whether for Github, Gitea, or Forgejo.
+func workflows(project string) ([]string, error) {
+	fullPaths := make([]string, 0)
+	for _, dir := range parsing.WorkflowDirs {
+		workflowDir := filepath.Join(project, dir)
+		items, err := ioutil.ReadDir(workflowDir)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		for _, file := range items {
+			if !file.IsDir() {
+				full := filepath.Join(workflowDir, file.Name())
+				fullPaths = append(fullPaths, full)
+			}
 		}
 	}
 	return fullPaths, nil
@@ -243,19 +390,26 @@ func workflows(project string) ([]string, error) {
 
 // Detect and apply updates.
 func applyUpdates(env *config.Env, project *Project) {
-	// ASSUMPTION: `env.L.Vers` has been fully written to, and will only be read
+	// ASSUMPTION: `env.L` has been fully written to, and will only be read
 	// from here on.
-	ls := env.L.Vers
 
 	// Apply updates, if the user wants them.
 	for _, wf := range project.workflows {
-		newAs := newActionVers(ls, wf.actions)
-		yamlNew := update(newAs, wf.yaml)
+		newAs, held := newActionVers(env.L, env.Conf, wf.actions)
+		yamlNew := update(env.Conf, newAs, wf.yaml)
+
+		// Report updates that were held back, even if there's nothing else.
+		if wf.yaml == yamlNew && len(held) > 0 {
+			env.T.Mut.Lock()
+			fmt.Printf("\nUpdates held back for %s: %s:\n", cyan(project.name), filepath.Base(wf.path))
+			printHeld(held)
+			env.T.Mut.Unlock()
+		}
 
 		// Only proceed if there were actually changes to consider.
 		if wf.yaml != yamlNew {
 			env.T.Mut.Lock()
-			resp := prompt(env, project.name, wf, newAs)
+			resp := prompt(env, project.name, wf, newAs, held)
 
 			if resp {
 				ioutil.WriteFile(wf.path, []byte(yamlNew), 0644)
@@ -263,8 +417,7 @@ func applyUpdates(env *config.Env, project *Project) {
 
 				// Mutability to communicate back to `main` that the user
 				// accepted these changes.
-				path := filepath.Join(".github/workflows", filepath.Base(wf.path))
-				project.accepted = append(project.accepted, path)
+				project.accepted = append(project.accepted, filepath.ToSlash(wf.rel))
 			} else {
 				fmt.Println("Skipping...")
 			}
@@ -277,7 +430,7 @@ func applyUpdates(env *config.Env, project *Project) {
 // support stashing, so if the working tree isn't clean, we have
 // to skip this Project entirely. This also pulls the latest master from
 // the remote.
-func switchBranches(c *config.Config, r *git.Repository, remote string, pname string) (string, error) {
+func switchBranches(ctx context.Context, c *config.Config, r *git.Repository, remote string, host string, pname string) (string, error) {
 	wt, e9 := r.Worktree()
 	if e9 != nil {
 		return "", e9
@@ -295,7 +448,7 @@ func switchBranches(c *config.Config, r *git.Repository, remote string, pname st
 	if e0 != nil {
 		return "", fmt.Errorf("Unable to switch branches for %s: %s", cyan(pname), e0)
 	}
-	e2 := gitutils.PullMaster(wt, remote, c.Git.User, c.Git.Token)
+	e2 := gitutils.PullMaster(ctx, wt, remote, c.Git.User, c.TokenFor(host, *tokenF))
 	if e2 != nil && e2 != git.NoErrAlreadyUpToDate {
 		return "", fmt.Errorf("Could not pull master for %s: %s", cyan(pname), e2)
 	}
@@ -304,96 +457,375 @@ func switchBranches(c *config.Config, r *git.Repository, remote string, pname st
 	if e1 != nil {
 		return "", fmt.Errorf("Unable to create a new branch for %s: %s", cyan(pname), e1)
 	}
+	created.Lock()
+	created.branches = append(created.branches, newBranch{pname, r, branch})
+	created.Unlock()
 	return branch, nil
 }
 
-// Read the workflow file, if we can. Exit otherwise, since the user
-// probably wasn't expecting that their file was unreadable.
-func readWorkflow(path string) string {
+// Read the workflow file, if we can.
+func readWorkflow(path string) (string, error) {
 	yamlRaw, err := ioutil.ReadFile(path)
-	utils.ExitIfErr(err)
-	return string(yamlRaw)
+	return string(yamlRaw), err
+}
+
+// Which repositories will need the commits of their releases to be resolved?
+// That's all of them in pinning mode, otherwise only those that are already
+// pinned somewhere.
+func pinnedRepos(c *config.Config, projects []*Project) map[string]bool {
+	pins := make(map[string]bool)
+	for _, proj := range projects {
+		for _, wf := range proj.workflows {
+			for _, action := range wf.actions {
+				if pinning(c, action) {
+					pins[c.Key(action)] = true
+				}
+			}
+		}
+	}
+	return pins
 }
 
-// Given some Actions, call the Github API and check for their latest versions.
-func register(env *config.Env, actions []parsing.Action) {
+// Should the given Action be pinned to a commit? Images never are, since
+// they're versioned by tag alone, and nor are steps that share a line, since
+// their versions couldn't each be noted.
+func pinning(c *config.Config, action parsing.Action) bool {
+	return action.Pinned() || (c.Pin && action.Kind != parsing.DockerImage && !action.Crowded)
+}
+
+// Given some projects, call the Github API and check for the latest versions
+// of their Actions, as many at once as `env.Jobs` allows.
+func register(ctx context.Context, env *config.Env, pins map[string]bool, projects []*Project) {
 	var wg sync.WaitGroup
-	for _, action := range actions {
-		wg.Add(1)
-		go func(action parsing.Action) {
-			versionLookup(env, action)
-			wg.Done()
-		}(action)
+	for _, proj := range projects {
+		for _, wf := range proj.workflows {
+			for _, action := range wf.actions {
+				// Only versioned references can be compared against a release.
+				if action.Version == "" || !env.Conf.Manages(action.Repo()) {
+					continue
+				}
+				action := action
+				env.Jobs.Go(&wg, func() {
+					versionLookup(ctx, env, action, pins[env.Conf.Key(action)])
+				})
+			}
+		}
 	}
 	wg.Wait()
 }
 
-// Concurrently query the Github API for Action versions.
-func versionLookup(env *config.Env, a parsing.Action) {
+// Concurrently look up Action versions through `env.Source`. If `pin` is set,
+// the commit of the latest release is looked up as well.
+func versionLookup(ctx context.Context, env *config.Env, a parsing.Action, pin bool) {
 	// Have we looked up this Action already?
 	env.W.Mut.Lock()
 	repo := a.Repo()
-	if seen := env.W.Seen[repo]; seen {
+	id := env.Conf.Key(a)
+	if seen := env.W.Seen[id]; seen {
 		env.W.Mut.Unlock()
 		return
 	}
-	env.W.Seen[repo] = true
+	env.W.Seen[id] = true
 	env.W.Mut.Unlock()
 
 	// Version lookup and recording.
-	version, err := gitutils.Recent(env.C, a.Owner, a.Name)
-	if err != nil {
+	ref := env.Conf.Ref(a)
+	source := env.Source
+	if a.Kind == parsing.DockerImage {
+		source = env.Images
+	}
+	cons := env.Conf.Constraint(repo)
+	cooldown := env.Conf.CooldownFor(a)
+	var release, held gitutils.Release
+	if cons.Empty() && cooldown == 0 {
+		r, e0 := source.LookupLatest(ctx, ref)
+		if e0 != nil {
+			failed(env, id, e0)
+			return
+		}
+		release = r
+	} else {
+		rs, e0 := source.LookupAll(ctx, ref)
+		if e0 != nil {
+			failed(env, id, e0)
+			return
+		}
+		r, found := allowed(rs, cons, cooldown)
+		if r.Tag != rs[0].Tag {
+			held = rs[0]
+		}
+		if !found {
+			env.L.Mut.Lock()
+			env.L.Held[id] = held
+			env.L.Mut.Unlock()
+			return
+		}
+		release = r
+	}
+	if pin && release.SHA == "" && *manifestF != "" {
+		// Resolving the commit would need the very network that's missing.
+		failed(env, id, fmt.Errorf("No SHA for %s@%s in the manifest.", id, release.Tag))
 		return
+	} else if pin && release.SHA == "" {
+		key := fmt.Sprintf("commit:%s@%s", id, release.Tag)
+		sha, e1 := env.Cache.Commit(key, func() (string, error) {
+			forge, err := env.Forge(ref.Host)
+			if err != nil {
+				return "", err
+			}
+			return forge.TagCommit(ctx, a.Owner, a.Name, release.Tag)
+		})
+		if e1 != nil {
+			failed(env, id, e1)
+			return
+		}
+		release.SHA = sha
+	}
+	env.L.Mut.Lock()
+	env.L.Vers[id] = release
+	if held.Tag != "" {
+		env.L.Held[id] = held
+	}
+	env.L.Mut.Unlock()
+}
+
+// The cache key that lookups of the given Action are recorded under. Plain
+// lookups only need the most recent release, but constraints and cooldowns
+// need to see them all.
+func lookupKey(c *config.Config, a parsing.Action) string {
+	if c.Constraint(a.Repo()).Empty() && c.CooldownFor(a) == 0 {
+		return cache.LatestKey(c.Ref(a))
+	}
+	return cache.AllKey(c.Ref(a))
+}
+
+// Write every version that was found to a manifest, which `--manifest` can use
+// to compute updates without contacting any host. Versions that were held back
+// are included too, so that they're still reported offline.
+func exportManifest(env *config.Env, path string) {
+	manifest := make(gitutils.StaticSource)
+	for id, r := range env.L.Vers {
+		manifest[id] = append(manifest[id], r)
+	}
+	for id, r := range env.L.Held {
+		if r.Tag != "" && r.Tag != env.L.Vers[id].Tag {
+			manifest[id] = append(manifest[id], r)
+		}
 	}
+	if err := gitutils.WriteStatic(path, manifest); err != nil {
+		utils.PrintExit(fmt.Sprintf("Unable to write the manifest: %s", err))
+	}
+	fmt.Printf("Wrote the versions of %d Actions to %s.\n", len(manifest), path)
+}
+
+// Look up every managed Action that isn't already cached through a few
+// GraphQL queries per host, rather than a REST request each. Hosts that aren't
+// Github, or that we have no token for, are left to the REST API.
+func prefetch(ctx context.Context, env *config.Env, projects []*Project) {
+	seen := make(map[string]bool)
+	byHost := make(map[string][]gitutils.Ref)
+	hosts := make([]string, 0)
+	for _, proj := range projects {
+		for _, wf := range proj.workflows {
+			for _, action := range wf.actions {
+				id := env.Conf.Key(action)
+				if action.Version == "" || seen[id] || !env.Conf.Manages(action.Repo()) || action.Kind == parsing.DockerImage {
+					continue
+				}
+				seen[id] = true
+				if _, fresh := env.Cache.Get(lookupKey(env.Conf, action)); !fresh {
+					ref := env.Conf.Ref(action)
+					if _, found := byHost[ref.Host]; !found {
+						hosts = append(hosts, ref.Host)
+					}
+					byHost[ref.Host] = append(byHost[ref.Host], ref)
+				}
+			}
+		}
+	}
+	for _, host := range hosts {
+		f, e0 := env.Forge(host)
+		gh, ok := f.(gitutils.Github)
+		if e0 != nil || !ok {
+			continue
+		} else if env.Conf.TokenFor(host, *tokenF) == "" {
+			fmt.Printf("The GraphQL API needs a token, so the REST API will be used for %s instead.\n", host)
+			continue
+		}
+		env.GraphQL.Prefetch(ctx, gh.Client, byHost[host])
+	}
+}
+
+// Record why the lookup of some `owner/repo` failed.
+func failed(env *config.Env, repo string, err error) {
 	env.L.Mut.Lock()
-	env.L.Vers[repo] = version
+	env.L.Failed[repo] = err
 	env.L.Mut.Unlock()
 }
 
-// For some Actions, what new version should they be assigned to?
-func newActionVers(ls map[string]string, actions []parsing.Action) map[parsing.Action]string {
-	news := make(map[parsing.Action]string)
+// Report every failed lookup, so that a shorter list of updates isn't mistaken
+// for there being fewer to make.
+func printFailures(env *config.Env, authed bool) {
+	if len(env.L.Failed) == 0 {
+		return
+	}
+	repos := make([]string, 0, len(env.L.Failed))
+	for repo := range env.L.Failed {
+		repos = append(repos, repo)
+	}
+	sort.Strings(repos)
+	limited := false
+	fmt.Println("Unable to look up the following Actions:")
+	for _, repo := range repos {
+		err := env.L.Failed[repo]
+		limited = limited || gitutils.RateLimited(err)
+		fmt.Printf("  --> %s: %s\n", cyan(repo), gitutils.Explain(err))
+	}
+	if limited && !authed {
+		fmt.Println("Giving a token with '--token' or in your config file raises the rate limit.")
+	}
+}
+
+// The newest of some releases (sorted newest-first) that the constraint allows,
+// and that has been public for at least the given cooldown.
+func allowed(rs []gitutils.Release, cons parsing.Constraint, cooldown time.Duration) (gitutils.Release, bool) {
+	cutoff := time.Now().Add(-cooldown)
+	for _, r := range rs {
+		if cons.Allows(r.Version) && (cooldown == 0 || r.PublishedBefore(cutoff)) {
+			return r, true
+		}
+	}
+	return gitutils.Release{}, false
+}
+
+// For some Actions, what new release should they be assigned to? Only strictly
+// newer versions are proposed, except when the config pins an Action to an
+// exact version. In pinning mode, Actions that aren't pinned yet are given one
+// even if their version is already the latest.
+//
+// Unpinned versions are written with the same granularity as the existing
+// reference (so `v2` might become `v4`), unless the config demands otherwise,
+// in which case current versions are rewritten too.
+//
+// Also yields the newer releases that the config's constraints or cooldown held
+// back.
+func newActionVers(l *config.Lookups, c *config.Config, actions []parsing.Action) (map[parsing.Action]gitutils.Release, map[parsing.Action]gitutils.Release) {
+	news := make(map[parsing.Action]gitutils.Release)
+	held := make(map[parsing.Action]gitutils.Release)
 	for _, action := range actions {
-		if v := ls[action.Repo()]; v != "" && action.Version != v {
-			news[action] = v
+		settings := c.Actions[action.Repo()]
+		if action.Version == "" || !c.Manages(action.Repo()) {
+			continue
+		}
+		if h, found := l.Held[c.Key(action)]; found && parsing.Newer(h.Version, action.Version) {
+			held[action] = h
+		}
+		r, found := l.Vers[c.Key(action)]
+		if !found {
+			continue
+		}
+		pinned := pinning(c, action)
+		if pinned && r.SHA == "" {
+			continue
+		}
+		latest, e0 := parsing.ParseSemver(r.Version)
+		if e0 != nil {
+			continue
+		}
+		// An exact version from the config is written as-is, even if it's older.
+		if settings.Pin != "" {
+			if action.Version != r.Version || (pinned && !action.Pinned()) {
+				news[action] = r
+			}
+			continue
+		}
+		// A configured granularity reshapes versions even when they're current,
+		// so under `full`, `v4` becomes `v4.1.1`, and under `major`, `v4.0`
+		// becomes `v4`.
+		reshape := false
+		if !pinned {
+			gran := c.VersionGranularity()
+			if gran == 0 {
+				gran = parsing.GranularityOf(action.Version)
+			}
+			// There won't be a floating tag like `v5` for a prerelease.
+			if latest.Pre != "" && gran < parsing.Patch {
+				continue
+			}
+			r.Version = gran.Truncate(r.Version)
+			reshape = gran != parsing.GranularityOf(action.Version) && r.Version != action.Version
+		}
+		newer := parsing.Newer(r.Version, action.Version)
+		older := parsing.Newer(action.Version, r.Version)
+		if newer || (reshape && !older) || (pinned && !action.Pinned() && !older) {
+			news[action] = r
 		}
 	}
-	return news
+	return news, held
 }
 
 // Given the Actions detected in some workflow file, try to replace them with
-// the newest versions available from Github.
-func update(actions map[parsing.Action]string, yaml string) string {
-	yamlNew := yaml
-	for action, v := range actions {
-		old := "uses: " + action.Raw()
-		new := "uses: " + action.Repo() + "@v" + v
-		yamlNew = strings.ReplaceAll(yamlNew, old, new)
-	}
-	return yamlNew
+// the newest versions available from Github. Only the exact `uses` values that
+// were detected are rewritten. Pinned Actions are given the new commit, with
+// its version noted in a comment.
+func update(c *config.Config, actions map[parsing.Action]gitutils.Release, yaml string) string {
+	edits := make([]parsing.Edit, 0, len(actions))
+	for action, r := range actions {
+		edit := parsing.Edit{
+			Line:   action.Line,
+			Column: action.Column,
+			Old:    action.Raw(),
+			New:    action.WithVersion(r.Version),
+		}
+		if pinning(c, action) {
+			edit.New = action.Location() + "@" + r.SHA
+			edit.Comment = r.Tag
+		}
+		edits = append(edits, edit)
+	}
+	return parsing.Apply(yaml, edits)
 }
 
 // We detected some changes to a workflow file, so we inform the user and ask
 // whether we should write the changes to disk.
-func prompt(env *config.Env, projName string, workflow *Workflow, newAs map[parsing.Action]string) bool {
+func prompt(env *config.Env, projName string, workflow *Workflow, newAs map[parsing.Action]gitutils.Release, held map[parsing.Action]gitutils.Release) bool {
 	longestName := 0
 	longestVer := 0
 	for action := range newAs {
-		if repo := action.Repo(); len(repo) > longestName {
-			longestName = len(repo)
+		if loc := action.Location(); len(loc) > longestName {
+			longestName = len(loc)
 		}
 		if len(action.Version) > longestVer {
 			longestVer = len(action.Version)
 		}
 	}
 	fmt.Printf("\nUpdates available for %s: %s:\n", cyan(projName), filepath.Base(workflow.path))
-	for action, v := range newAs {
-		repo := action.Repo()
-		nameDiff := longestName - len(repo)
+	seen := make(map[string]bool)
+	for _, action := range inFileOrder(newAs) {
+		r := newAs[action]
+		// The same Action may be used in several places within one file.
+		if seen[action.Raw()] {
+			continue
+		}
+		seen[action.Raw()] = true
+		loc := action.Location()
+		nameDiff := longestName - len(loc)
 		verDiff := longestVer - len(action.Version)
 		spaces := strings.Repeat(" ", nameDiff+verDiff+1)
 		patt := "  %s" + spaces + "%s --> %s\n"
-		fmt.Printf(patt, repo, yellow(action.Version), green(v))
+		v := r.Version
+		if r.SHA != "" && pinning(env.Conf, action) {
+			v += " (" + r.SHA[:7] + ")"
+		}
+		note := ""
+		if r.Source == gitutils.FromTag {
+			note = " (from tags)"
+		}
+		fmt.Printf(patt, loc, yellow(action.Version), green(v)+note)
+	}
+	if len(held) > 0 {
+		fmt.Println("Held back by your config:")
+		printHeld(held)
 	}
 
 	resp := "NO"
@@ -405,20 +837,55 @@ func prompt(env *config.Env, projName string, workflow *Workflow, newAs map[pars
 	return *autoF || resp == "Y" || resp == "y" || resp == ""
 }
 
+// The Actions of a workflow in the order they appear in its file, so that
+// they're always reported the same way.
+func inFileOrder(actions map[parsing.Action]gitutils.Release) []parsing.Action {
+	sorted := make([]parsing.Action, 0, len(actions))
+	for action := range actions {
+		sorted = append(sorted, action)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Line != sorted[j].Line {
+			return sorted[i].Line < sorted[j].Line
+		}
+		return sorted[i].Column < sorted[j].Column
+	})
+	return sorted
+}
+
+// Report newer versions that the config's constraints didn't allow.
+func printHeld(held map[parsing.Action]gitutils.Release) {
+	seen := make(map[string]bool)
+	for _, action := range inFileOrder(held) {
+		r := held[action]
+		if seen[action.Raw()] {
+			continue
+		}
+		seen[action.Raw()] = true
+		fmt.Printf("  %s %s (%s is available)\n", action.Location(), yellow(action.Version), r.Version)
+	}
+}
+
 // Attempt to commit the changes, push the branch, and open a new PR.
 // The yielded int is the number of the new PR, if opened.
-func commitAndPush(client *github.Client, c *config.Config, p *Project) (int, error) {
+func commitAndPush(ctx context.Context, env *config.Env, p *Project) (int, error) {
+	c := env.Conf
 	e0 := gitutils.Commit(p.repo, c.Git.Name, c.Git.Email, p.accepted)
 	if e0 != nil {
 		return 0, fmt.Errorf("Couldn't commit %s: %s\n", cyan(p.name), e0)
 	}
-	e1 := gitutils.Push(p.repo, p.remote, p.branch, c.Git.User, c.Git.Token)
+	token := c.TokenFor(p.host, *tokenF)
+	e1 := gitutils.Push(ctx, p.repo, p.remote, p.branch, c.Git.User, token)
 	if e1 != nil {
 		return 0, fmt.Errorf("Unable to push %s to Github: %s\n", cyan(p.name), e1)
 	}
-	pr, e2 := gitutils.PullRequest(client, p.owner, p.name, p.branch)
+	forge, e2 := env.Forge(p.host)
 	if e2 != nil {
-		return 0, fmt.Errorf("Opening a PR for %s failed: %s\n", cyan(p.name), e2)
+		return 0, e2
+	}
+	pr, e3 := forge.PullRequest(ctx, p.owner, p.name, p.branch)
+	if e3 != nil {
+		return 0, fmt.Errorf("Opening a PR for %s failed: %s\n", cyan(p.name), e3)
 	}
 	return pr, nil
 }
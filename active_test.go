@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fosskers/active/cache"
+	"github.com/fosskers/active/config"
+	"github.com/fosskers/active/gitutils"
+	"github.com/fosskers/active/parsing"
+	"github.com/fosskers/active/utils"
+)
+
+func TestInFileOrder(t *testing.T) {
+	actions := map[parsing.Action]gitutils.Release{
+		{Owner: "c", Name: "c", Line: 9, Column: 15}:  {},
+		{Owner: "a", Name: "a", Line: 2, Column: 15}:  {},
+		{Owner: "b", Name: "b", Line: 9, Column: 7}:   {},
+		{Owner: "d", Name: "d", Line: 12, Column: 15}: {},
+	}
+	for i := 0; i < 10; i++ {
+		sorted := inFileOrder(actions)
+		names := ""
+		for _, a := range sorted {
+			names += a.Owner
+		}
+		if names != "abcd" {
+			t.Fatalf("inFileOrder: expected abcd, got %s", names)
+		}
+	}
+}
+
+func TestManifestWithoutSHA(t *testing.T) {
+	defer func(m string) { *manifestF = m }(*manifestF)
+	*manifestF = "versions.json"
+	c := &config.Config{}
+	env := config.RuntimeEnv(context.Background(), c, "", cache.Open("", 0, false), utils.NewPool(1), 0)
+	env.Source = gitutils.StaticSource{"actions/checkout": {{Tag: "v4.1.1", Version: "4.1.1"}}}
+
+	a, _ := parsing.ParseAction("actions/checkout@v2")
+	versionLookup(context.Background(), env, a, true)
+	err := env.L.Failed["actions/checkout"]
+	if err == nil || err.Error() != "No SHA for actions/checkout@v4.1.1 in the manifest." {
+		t.Errorf("versionLookup: expected a missing SHA to fail without a lookup, got %v", err)
+	}
+}
+
+func TestGranularityRewrites(t *testing.T) {
+	cases := []struct {
+		granularity string
+		uses        string
+		latest      string
+		expected    string // Empty if no update should be proposed.
+	}{
+		{"full", "actions/checkout@v4", "4.1.1", "actions/checkout@v4.1.1"},
+		{"full", "actions/checkout@v4.1.1", "4.1.1", ""},
+		{"full", "actions/checkout@v5", "4.1.1", ""},
+		{"major", "actions/checkout@v4.0", "4.1.1", "actions/checkout@v4"},
+		{"major", "actions/checkout@4.0.0", "4.1.1", "actions/checkout@4"},
+		{"major", "actions/checkout@v4", "4.1.1", ""},
+		{"minor", "actions/checkout@v4", "5.2.0", "actions/checkout@v5.2"},
+		{"", "actions/checkout@v4", "4.1.1", ""},
+		{"", "actions/checkout@v4.0", "4.1.1", "actions/checkout@v4.1"},
+	}
+	for _, c := range cases {
+		conf := &config.Config{Granularity: c.granularity}
+		a, _ := parsing.ParseAction(c.uses)
+		l := &config.Lookups{Vers: map[string]gitutils.Release{conf.Key(a): {Tag: a.Prefix + c.latest, Version: c.latest}}}
+		news, _ := newActionVers(l, conf, []parsing.Action{a})
+		actual := ""
+		if r, found := news[a]; found {
+			actual = a.WithVersion(r.Version)
+		}
+		if actual != c.expected {
+			t.Errorf("newActionVers(%s) under %q: expected %q, got %q", c.uses, c.granularity, c.expected, actual)
+		}
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	day := 24 * time.Hour
+	rs := []gitutils.Release{
+		{Tag: "v5.0.0", Version: "5.0.0", Published: time.Now().Add(-day)},
+		{Tag: "v4.2.0", Version: "4.2.0", Published: time.Now().Add(-30 * day)},
+		{Tag: "v4.1.0", Version: "4.1.0"},
+		{Tag: "v3.0.0", Version: "3.0.0", Published: time.Now().Add(-90 * day)},
+	}
+	cases := []struct {
+		constraint string
+		cooldown   time.Duration
+		expected   string // Empty if nothing should be allowed.
+	}{
+		{"", 0, "v5.0.0"},
+		{"<5", 0, "v4.2.0"},
+		{"~4.1", 0, "v4.1.0"},
+		{">=6", 0, ""},
+		{"", 7 * day, "v4.2.0"},
+		{"~4.1", 7 * day, ""},
+		{"<4.2", 7 * day, "v3.0.0"},
+		{"", 365 * day, ""},
+	}
+	for _, c := range cases {
+		cons, e0 := parsing.ParseConstraint(c.constraint)
+		if e0 != nil {
+			t.Fatal(e0)
+		}
+		r, found := allowed(rs, cons, c.cooldown)
+		if r.Tag != c.expected || found != (c.expected != "") {
+			t.Errorf("allowed(%q, %s): expected %q, got %q (%v)", c.constraint, c.cooldown, c.expected, r.Tag, found)
+		}
+	}
+}
+
+func TestHeldVersions(t *testing.T) {
+	cases := []struct {
+		name     string
+		settings config.ActionSettings
+		uses     string
+		vers     *gitutils.Release // The allowed release, if any was.
+		held     gitutils.Release
+		news     string // Empty if no update should be proposed.
+		expected string // The held version that should be reported, if any.
+	}{
+		{"held back by a constraint", config.ActionSettings{Version: "<5"}, "actions/checkout@v3",
+			&gitutils.Release{Tag: "v4.2.0", Version: "4.2.0"}, gitutils.Release{Tag: "v5.0.0", Version: "5.0.0"},
+			"actions/checkout@v4", "5.0.0"},
+		{"everything blocked", config.ActionSettings{Version: ">=9"}, "actions/checkout@v3",
+			nil, gitutils.Release{Tag: "v5.0.0", Version: "5.0.0"},
+			"", "5.0.0"},
+		{"held but already current", config.ActionSettings{Version: "<5"}, "actions/checkout@v5",
+			&gitutils.Release{Tag: "v4.2.0", Version: "4.2.0"}, gitutils.Release{Tag: "v5.0.0", Version: "5.0.0"},
+			"", ""},
+		{"pinned older than current", config.ActionSettings{Pin: "2.0.0"}, "actions/checkout@v3",
+			&gitutils.Release{Tag: "v2.0.0", Version: "2.0.0"}, gitutils.Release{Tag: "v5.0.0", Version: "5.0.0"},
+			"actions/checkout@v2.0.0", "5.0.0"},
+		{"prerelease with a major reference", config.ActionSettings{Version: "<6"}, "actions/checkout@v4",
+			&gitutils.Release{Tag: "v5.0.0-rc.1", Version: "5.0.0-rc.1", Prerelease: true}, gitutils.Release{Tag: "v6.0.0", Version: "6.0.0"},
+			"", "6.0.0"},
+		{"prerelease with a full reference", config.ActionSettings{Version: "<6"}, "actions/checkout@v4.0.0",
+			&gitutils.Release{Tag: "v5.0.0-rc.1", Version: "5.0.0-rc.1", Prerelease: true}, gitutils.Release{Tag: "v6.0.0", Version: "6.0.0"},
+			"actions/checkout@v5.0.0-rc.1", "6.0.0"},
+	}
+	for _, c := range cases {
+		conf := &config.Config{Actions: map[string]config.ActionSettings{"actions/checkout": c.settings}}
+		a, _ := parsing.ParseAction(c.uses)
+		l := &config.Lookups{Vers: map[string]gitutils.Release{}, Held: map[string]gitutils.Release{conf.Key(a): c.held}}
+		if c.vers != nil {
+			l.Vers[conf.Key(a)] = *c.vers
+		}
+		news, held := newActionVers(l, conf, []parsing.Action{a})
+		actual := ""
+		if r, found := news[a]; found {
+			actual = a.WithVersion(r.Version)
+		}
+		if actual != c.news {
+			t.Errorf("newActionVers, %s: expected update %q, got %q", c.name, c.news, actual)
+		}
+		if h := held[a]; h.Version != c.expected {
+			t.Errorf("newActionVers, %s: expected %q to be held, got %q", c.name, c.expected, h.Version)
+		}
+	}
+}
+
+func TestImageCooldown(t *testing.T) {
+	c := &config.Config{Cooldown: 7}
+	env := config.RuntimeEnv(context.Background(), c, "", cache.Open("", 0, false), utils.NewPool(1), 0)
+	env.Images = gitutils.StaticSource{"docker.io/library/node": {{Tag: "20", Version: "20", Source: gitutils.FromRegistry}}}
+
+	a, _ := parsing.ParseImage("node:16")
+	versionLookup(context.Background(), env, a, false)
+	if r := env.L.Vers["docker.io/library/node"]; r.Tag != "20" {
+		t.Errorf("versionLookup: expected an image to be updated despite the cooldown, got %+v (%v)", r, env.L.Failed["docker.io/library/node"])
+	}
+	if h, found := env.L.Held["docker.io/library/node"]; found {
+		t.Errorf("versionLookup: expected nothing to be held back, got %s", h.Tag)
+	}
+}
+
+func TestPinCrowdedSteps(t *testing.T) {
+	sha := "a81bbbf8298c0fa03ea29cdc473d45769f953675"
+	yaml := "jobs:\n  a:\n    steps: [{uses: a/b@v1}, {uses: c/d@v1}]\n  b:\n    steps:\n    - uses: e/f@v1\n"
+	c := &config.Config{Pin: true}
+	w, _ := parsing.ParseWorkflow(yaml)
+	actions, _ := parsing.Actions(w)
+	l := &config.Lookups{Vers: map[string]gitutils.Release{}}
+	for _, repo := range []string{"a/b", "c/d", "e/f"} {
+		l.Vers[repo] = gitutils.Release{Tag: "v2.0.0", Version: "2.0.0", SHA: sha}
+	}
+	news, _ := newActionVers(l, c, actions)
+	again, e0 := parsing.ParseWorkflow(update(c, news, yaml))
+	if e0 != nil {
+		t.Fatal(e0)
+	}
+	updated, _ := parsing.Actions(again)
+	expected := []string{"a/b@v2", "c/d@v2", "e/f@" + sha}
+	if len(updated) != len(expected) {
+		t.Fatalf("update: expected %v, got %v", expected, updated)
+	}
+	for i, a := range updated {
+		if a.Raw() != expected[i] || a.Version == "" {
+			t.Errorf("update: expected %s with a version, got %s (%q)", expected[i], a.Raw(), a.Version)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package cache
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fosskers/active/gitutils"
+)
+
+// The results of previous Github lookups, persisted between runs so that we
+// don't spend our API rate limit on answers we already have.
+type Cache struct {
+	path    string
+	ttl     time.Duration
+	refresh bool // Ignore existing entries, but still record new ones.
+	entries map[string]Entry
+	mut     sync.Mutex
+}
+
+// The result of a single lookup, and when it was made.
+type Entry struct {
+	Fetched  time.Time          `json:"fetched"`
+	Releases []gitutils.Release `json:"releases,omitempty"`
+	SHA      string             `json:"sha,omitempty"`
+	// For asking Github whether `Releases` have changed once they're stale.
+	Validators gitutils.Validators `json:"validators"`
+}
+
+// How long stale entries with `Validators` are kept, beyond their TTL, so that
+// they can still be revalidated cheaply.
+const revalidateFor = 7 * 24 * time.Hour
+
+// Where the cache file lives by default: `$XDG_CACHE_HOME/active/lookups.json`,
+// or the platform's equivalent.
+func DefaultPath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "active", "lookups.json")
+}
+
+// Read the cache file at the given path, if there is one. A missing or corrupt
+// file just yields an empty cache. A `ttl` of zero disables caching entirely.
+func Open(path string, ttl time.Duration, refresh bool) *Cache {
+	c := Cache{path: path, ttl: ttl, refresh: refresh, entries: make(map[string]Entry)}
+	if path == "" || ttl <= 0 {
+		return &c
+	}
+	file, e0 := ioutil.ReadFile(path)
+	if e0 != nil {
+		return &c
+	}
+	entries := make(map[string]Entry)
+	if e1 := json.Unmarshal(file, &entries); e1 == nil {
+		c.entries = entries
+	}
+	return &c
+}
+
+// Is caching happening at all?
+func (c *Cache) Enabled() bool {
+	return c.path != "" && c.ttl > 0
+}
+
+// A cached entry, if it exists and is still fresh.
+func (c *Cache) Get(key string) (Entry, bool) {
+	if !c.Enabled() || c.refresh {
+		return Entry{}, false
+	}
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	e, found := c.entries[key]
+	if !found || time.Since(e.Fetched) > c.ttl {
+		return Entry{}, false
+	}
+	return e, true
+}
+
+// Record a fresh entry.
+func (c *Cache) Put(key string, e Entry) {
+	if !c.Enabled() {
+		return
+	}
+	e.Fetched = time.Now()
+	c.mut.Lock()
+	c.entries[key] = e
+	c.mut.Unlock()
+}
+
+// Either yield the cached releases for the given key, or fetch and cache them.
+// Once an entry is stale, its `Validators` are passed along to `fetch`, so that
+// it can report `gitutils.ErrNotModified` and have the entry renewed as-is.
+func (c *Cache) Releases(key string, fetch func(gitutils.Validators) ([]gitutils.Release, gitutils.Validators, error)) ([]gitutils.Release, error) {
+	if e, found := c.Get(key); found && len(e.Releases) > 0 {
+		return e.Releases, nil
+	}
+	prev := c.stale(key)
+	rs, vs, err := fetch(prev.Validators)
+	if err == gitutils.ErrNotModified && len(prev.Releases) > 0 {
+		c.Put(key, prev)
+		return prev.Releases, nil
+	} else if err != nil {
+		return nil, err
+	}
+	c.Put(key, Entry{Releases: rs, Validators: vs})
+	return rs, nil
+}
+
+// Whatever entry exists for the given key, no matter its age.
+func (c *Cache) stale(key string) Entry {
+	if !c.Enabled() {
+		return Entry{}
+	}
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	return c.entries[key]
+}
+
+// Either yield the cached commit for the given key, or fetch and cache it.
+func (c *Cache) Commit(key string, fetch func() (string, error)) (string, error) {
+	if e, found := c.Get(key); found && e.SHA != "" {
+		return e.SHA, nil
+	}
+	sha, err := fetch()
+	if err != nil {
+		return "", err
+	}
+	c.Put(key, Entry{SHA: sha})
+	return sha, nil
+}
+
+// Write the cache back to disk. Stale entries are dropped along the way, unless
+// they can still be revalidated.
+func (c *Cache) Save() error {
+	if !c.Enabled() {
+		return nil
+	}
+	c.mut.Lock()
+	fresh := make(map[string]Entry)
+	for k, e := range c.entries {
+		age := time.Since(e.Fetched)
+		if age <= c.ttl || (e.Validators.URL != "" && age <= c.ttl+revalidateFor) {
+			fresh[k] = e
+		}
+	}
+	c.mut.Unlock()
+
+	bytes, e0 := json.Marshal(fresh)
+	if e0 != nil {
+		return e0
+	}
+	if e1 := os.MkdirAll(filepath.Dir(c.path), 0755); e1 != nil {
+		return e1
+	}
+	// Write to a temporary file first, so that a crash never leaves a
+	// half-written cache behind.
+	tmp := c.path + ".tmp"
+	if e2 := ioutil.WriteFile(tmp, bytes, 0644); e2 != nil {
+		return e2
+	}
+	return os.Rename(tmp, c.path)
+}
@@ -0,0 +1,122 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fosskers/active/gitutils"
+)
+
+func TestRoundTrip(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "active")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "nested", "lookups.json")
+
+	c := Open(path, time.Hour, false)
+	calls := 0
+	fetch := func(gitutils.Validators) ([]gitutils.Release, gitutils.Validators, error) {
+		calls++
+		return []gitutils.Release{{Tag: "v2.1.0", Version: "2.1.0"}}, gitutils.Validators{}, nil
+	}
+	c.Releases("actions/checkout", fetch)
+	c.Releases("actions/checkout", fetch)
+	if calls != 1 {
+		t.Errorf("Releases: expected one fetch, got %d", calls)
+	}
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	rs, _ := Open(path, time.Hour, false).Releases("actions/checkout", fetch)
+	if calls != 1 || len(rs) != 1 || rs[0].Tag != "v2.1.0" {
+		t.Errorf("Open: expected the saved entry, got %v after %d fetches", rs, calls)
+	}
+	Open(path, time.Hour, true).Releases("actions/checkout", fetch)
+	if calls != 2 {
+		t.Errorf("Open: expected a refresh to bypass the cache")
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	c := Open("unused", time.Minute, false)
+	c.entries["old"] = Entry{Fetched: time.Now().Add(-time.Hour), SHA: "abc"}
+	if _, found := c.Get("old"); found {
+		t.Errorf("Get: expected a stale entry to be ignored")
+	}
+}
+
+func TestRevalidate(t *testing.T) {
+	c := Open("unused", time.Minute, false)
+	old := gitutils.Validators{URL: "repos/actions/checkout/releases/latest", ETag: `"abc"`}
+	c.entries["recent"] = Entry{
+		Fetched:    time.Now().Add(-time.Hour),
+		Releases:   []gitutils.Release{{Tag: "v2.1.0", Version: "2.1.0"}},
+		Validators: old,
+	}
+	var given gitutils.Validators
+	rs, err := c.Releases("recent", func(v gitutils.Validators) ([]gitutils.Release, gitutils.Validators, error) {
+		given = v
+		return nil, v, gitutils.ErrNotModified
+	})
+	if err != nil || len(rs) != 1 || rs[0].Tag != "v2.1.0" {
+		t.Errorf("Releases: expected the stale entry to be reused, got %v, %v", rs, err)
+	}
+	if given != old {
+		t.Errorf("Releases: expected the stale validators to be given, got %v", given)
+	}
+	if _, found := c.Get("recent"); !found {
+		t.Errorf("Releases: expected the entry to be fresh again")
+	}
+}
+
+func TestFetchError(t *testing.T) {
+	c := Open("unused", time.Minute, false)
+	_, err := c.Commit("x", func() (string, error) { return "", errors.New("boom") })
+	if _, found := c.Get("x"); err == nil || found {
+		t.Errorf("Commit: errors should be returned and not cached")
+	}
+}
+
+// A source that counts how often it's asked.
+type countingSource struct {
+	calls *int
+}
+
+func (s countingSource) LookupLatest(ctx context.Context, ref gitutils.Ref) (gitutils.Release, error) {
+	*s.calls++
+	return gitutils.Release{Tag: "v4.1.1", Version: "4.1.1"}, nil
+}
+
+func (s countingSource) LookupAll(ctx context.Context, ref gitutils.Ref) ([]gitutils.Release, error) {
+	*s.calls++
+	return nil, errors.New("No releases.")
+}
+
+func TestSource(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "active")
+	defer os.RemoveAll(dir)
+	calls := 0
+	s := Source{Cache: Open(filepath.Join(dir, "lookups.json"), time.Hour, false), Next: countingSource{&calls}}
+	ctx := context.Background()
+	ref := gitutils.Ref{Host: "github.com", Owner: "actions", Name: "checkout", Prefix: "v"}
+
+	for i := 0; i < 2; i++ {
+		if r, err := s.LookupLatest(ctx, ref); err != nil || r.Tag != "v4.1.1" {
+			t.Errorf("LookupLatest: expected v4.1.1, got %s (%v)", r.Tag, err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("LookupLatest: expected one lookup, got %d", calls)
+	}
+	if _, ok := s.Cache.Get("recent:actions/checkout:false"); !ok {
+		t.Errorf("LookupLatest: expected the result to be cached under the usual key")
+	}
+	if _, err := s.LookupAll(ctx, ref); err == nil {
+		t.Errorf("LookupAll: expected the error to be passed along")
+	}
+}
@@ -0,0 +1,42 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/fosskers/active/gitutils"
+)
+
+// A `gitutils.VersionSource` that answers from the cache when it can, and
+// otherwise asks `Next` and records what it says.
+type Source struct {
+	Cache *Cache
+	Next  gitutils.VersionSource
+}
+
+// The key that the newest release of a project is cached under.
+func LatestKey(ref gitutils.Ref) string {
+	return fmt.Sprintf("recent:%s:%t", ref.ID(), ref.Pre)
+}
+
+// The key that every release of a project is cached under.
+func AllKey(ref gitutils.Ref) string {
+	return fmt.Sprintf("releases:%s:%t", ref.ID(), ref.Pre)
+}
+
+func (s Source) LookupLatest(ctx context.Context, ref gitutils.Ref) (gitutils.Release, error) {
+	rs, err := s.Cache.Releases(LatestKey(ref), func(v gitutils.Validators) ([]gitutils.Release, gitutils.Validators, error) {
+		r, vs, err := gitutils.LatestSince(ctx, s.Next, ref, v)
+		return []gitutils.Release{r}, vs, err
+	})
+	if err != nil {
+		return gitutils.Release{}, err
+	}
+	return rs[0], nil
+}
+
+func (s Source) LookupAll(ctx context.Context, ref gitutils.Ref) ([]gitutils.Release, error) {
+	return s.Cache.Releases(AllKey(ref), func(v gitutils.Validators) ([]gitutils.Release, gitutils.Validators, error) {
+		return gitutils.AllSince(ctx, s.Next, ref, v)
+	})
+}
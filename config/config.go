@@ -3,10 +3,19 @@ package config
 import (
 	"bufio"
 	"context"
+	"fmt"
 	"io/ioutil"
+	"net/http"
 	"os"
+	"path"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/fosskers/active/cache"
+	"github.com/fosskers/active/gitutils"
+	"github.com/fosskers/active/parsing"
 	"github.com/fosskers/active/utils"
 	"github.com/google/go-github/v31/github"
 	"golang.org/x/oauth2"
@@ -17,6 +26,68 @@ import (
 type Config struct {
 	Projects []string `yaml:"projects"`
 	Git      Git      `yaml:"git"`
+	Pin      bool     `yaml:"pin"` // Pin Actions to the commit of their release.
+	// Force new versions to be written as `major`, `minor`, or `full`. By
+	// default, the granularity of each existing reference is kept.
+	Granularity string `yaml:"granularity"`
+	Prereleases bool   `yaml:"prereleases"` // Offer prereleases as updates.
+	// Days that must pass after a release is published before it's offered.
+	Cooldown int `yaml:"cooldown"`
+	// Settings for individual Actions, keyed by `owner/repo`. These override
+	// the global ones above.
+	Actions map[string]ActionSettings `yaml:"actions"`
+	// Settings for all the Actions of an owner, like `actions`.
+	Owners map[string]OwnerSettings `yaml:"owners"`
+	// Patterns like `actions/*` or `our-org` that limit which Actions are
+	// managed. When `Include` is empty, everything not excluded is.
+	Include []string `yaml:"include"`
+	Exclude []string `yaml:"exclude"`
+	Cache   Cache    `yaml:"cache"`
+	// How versions are looked up: `rest` (the default), or `graphql`, which
+	// looks up many Actions per request but needs a token.
+	Backend string `yaml:"backend"`
+	// The Github instance that Actions are looked up on and PRs are opened
+	// against, like `github.example.com` for Github Enterprise. Individual
+	// Actions and owners may override it.
+	Host  string                  `yaml:"host"`
+	Hosts map[string]HostSettings `yaml:"hosts"` // Keyed by host name.
+}
+
+// How to talk to a particular forge.
+type HostSettings struct {
+	// Either `github` or `gitea` (which includes Forgejo). Well-known Gitea
+	// hosts like `code.forgejo.org` are recognized, but others default to
+	// `github`.
+	Kind    string `yaml:"kind"`
+	API     string `yaml:"api"`     // Defaults to `https://<host>/api/v3/` for Enterprise, or `/api/v1/` for Gitea.
+	Uploads string `yaml:"uploads"` // Defaults to the same as `API`.
+	// Defaults to `git.token` for the main `host`. Other hosts have no token
+	// unless one is given here.
+	Token string `yaml:"token"`
+}
+
+// Where Actions are looked up by default.
+const DefaultHost = "github.com"
+
+// Public Gitea and Forgejo instances that Actions are commonly found on.
+var giteaHosts = map[string]bool{"code.forgejo.org": true, "codeberg.org": true, "gitea.com": true}
+
+type Cache struct {
+	Path string `yaml:"path"` // Defaults to `$XDG_CACHE_HOME/active/lookups.json`.
+	TTL  string `yaml:"ttl"`  // Like `6h`. Defaults to one hour, and `0` disables caching.
+}
+
+type OwnerSettings struct {
+	Cooldown *int   `yaml:"cooldown"`
+	Host     string `yaml:"host"`
+}
+
+type ActionSettings struct {
+	Prereleases *bool  `yaml:"prereleases"`
+	Version     string `yaml:"version"` // A constraint like `~2` or `<4`.
+	Pin         string `yaml:"pin"`     // An exact version to stay on, like `2.1.0`.
+	Ignore      bool   `yaml:"ignore"`  // Never look up or update this Action.
+	Host        string `yaml:"host"`    // Overrides the owner's and the global `host`.
 }
 
 type Git struct {
@@ -38,9 +109,15 @@ type Witness struct {
 // thereof. This is separate from `Witness`, since all _attempted_ lookups might
 // not have had an actual result. Keeping them separate also allows for slightly
 // less locking.
+//
+// `Held` records the newest release of an Action whenever its constraints or
+// cooldown in the config file ruled that release out, and `Failed` records
+// why a lookup couldn't be made at all.
 type Lookups struct {
-	Vers map[string]string
-	Mut  sync.Mutex
+	Vers   map[string]gitutils.Release
+	Held   map[string]gitutils.Release
+	Failed map[string]error
+	Mut    sync.Mutex
 }
 
 // If changes were detected for a given workflow file, we want to prompt the
@@ -56,11 +133,41 @@ type Terminal struct {
 // of function calls. Not every function that receives `Env` will need every
 // value, but in practice this isn't a problem.
 type Env struct {
-	C    *github.Client
-	W    *Witness
-	L    *Lookups
-	T    *Terminal
-	Conf *Config
+	W     *Witness
+	L     *Lookups
+	T     *Terminal
+	Conf  *Config
+	Cache *cache.Cache
+	// Where versions are looked up: the cache, then the GraphQL API if that's
+	// the backend, then each host's REST API.
+	Source  gitutils.VersionSource
+	GraphQL *gitutils.GraphQLSource // Nil unless it's the backend.
+	Images  gitutils.VersionSource  // For container images, via their registries.
+	Jobs    utils.Pool              // Shared by everything that calls Github or runs git.
+	// Cancelled if `active` is interrupted. Each stage of the run, like
+	// looking up versions, gets `Timeout` to finish; see `Stage`.
+	Ctx     context.Context
+	Timeout time.Duration
+	Token   string // Given on the command line, if any.
+	// A forge for every host talked to so far, keyed by name.
+	forges   map[string]gitutils.Forge
+	forgeMut sync.Mutex
+}
+
+// The forge for the given host, which is only created once it's needed, since
+// Actions given by full URL can name any host at all.
+func (e *Env) Forge(host string) (gitutils.Forge, error) {
+	e.forgeMut.Lock()
+	defer e.forgeMut.Unlock()
+	if f, found := e.forges[host]; found {
+		return f, nil
+	}
+	f, err := HostForge(e.Conf, host, e.Token)
+	if err != nil {
+		return nil, err
+	}
+	e.forges[host] = f
+	return f, nil
 }
 
 // Doesn't mind if the expected fields are missing from the config file.
@@ -73,29 +180,292 @@ func ReadConfig(path string) *Config {
 		e1 := yaml.Unmarshal(file, &c)
 		utils.ExitIfErr(e1)
 	}
+	_, e2 := parsing.ParseGranularity(c.Granularity)
+	utils.ExitIfErr(e2)
+	for repo := range c.Actions {
+		_, e3 := c.constraint(repo)
+		if e3 != nil {
+			utils.PrintExit(fmt.Sprintf("Bad settings for %s: %s", repo, e3))
+		}
+	}
+	e4 := validPatterns(append(c.Include, c.Exclude...))
+	utils.ExitIfErr(e4)
+	if c.Cache.TTL != "" {
+		_, e5 := time.ParseDuration(c.Cache.TTL)
+		utils.ExitIfErr(e5)
+	}
+	for host, settings := range c.Hosts {
+		if settings.Kind != "" && settings.Kind != "github" && settings.Kind != "gitea" {
+			utils.PrintExit(fmt.Sprintf("Unknown kind of host for %s: %s", host, settings.Kind))
+		}
+	}
+	if c.Backend != "" && c.Backend != "rest" && c.Backend != "graphql" {
+		utils.PrintExit(fmt.Sprintf("Unknown backend: %s", c.Backend))
+	}
+	if c.Cache.Path == "" {
+		c.Cache.Path = cache.DefaultPath()
+	}
 	return &c
 }
 
-func GithubClient(config *Config, token *string) *github.Client {
-	if *token == "" && config.Git.Token == "" {
-		return github.NewClient(nil)
-	} else {
-		tok := *token
-		if tok == "" {
-			tok = config.Git.Token
+// Should prereleases be considered for the given `owner/repo`?
+func (c *Config) AllowPrereleases(repo string) bool {
+	if p := c.Actions[repo].Prereleases; p != nil {
+		return *p
+	}
+	return c.Prereleases
+}
+
+// How long cached lookups remain fresh. Assumes that the config has already
+// been validated by `ReadConfig`.
+func (c *Config) CacheTTL() time.Duration {
+	if c.Cache.TTL == "" {
+		return time.Hour
+	}
+	ttl, _ := time.ParseDuration(c.Cache.TTL)
+	return ttl
+}
+
+// Should `active` look up and update the given `owner/repo` at all?
+func (c *Config) Manages(repo string) bool {
+	if c.Actions[repo].Ignore || matchesAny(c.Exclude, repo) {
+		return false
+	}
+	return len(c.Include) == 0 || matchesAny(c.Include, repo)
+}
+
+func validPatterns(patterns []string) error {
+	for _, patt := range patterns {
+		if _, err := path.Match(patt, ""); err != nil {
+			return fmt.Errorf("Bad pattern %q: %s", patt, err)
+		}
+	}
+	return nil
+}
+
+// Patterns are globs over `owner/repo`, or a bare owner name.
+func matchesAny(patterns []string, repo string) bool {
+	owner := strings.SplitN(repo, "/", 2)[0]
+	for _, patt := range patterns {
+		if !strings.Contains(patt, "/") {
+			if m, _ := path.Match(patt, owner); m {
+				return true
+			}
+		} else if m, _ := path.Match(patt, repo); m {
+			return true
 		}
+	}
+	return false
+}
+
+// How long the releases of the given Action must have been public before they
+// can be offered. Registries don't say when an image's tags were pushed, so
+// images have no cooldown.
+func (c *Config) CooldownFor(a parsing.Action) time.Duration {
+	if a.Kind == parsing.DockerImage {
+		return 0
+	}
+	days := c.Cooldown
+	if d := c.Owners[a.Owner].Cooldown; d != nil {
+		days = *d
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// The versions that the given `owner/repo` is allowed to be updated to. An
+// exact `pin` takes precedence over a `version` range. Assumes that the config
+// has already been validated by `ReadConfig`.
+func (c *Config) Constraint(repo string) parsing.Constraint {
+	cons, _ := c.constraint(repo)
+	return cons
+}
+
+func (c *Config) constraint(repo string) (parsing.Constraint, error) {
+	settings := c.Actions[repo]
+	if settings.Pin != "" {
+		return parsing.ParseConstraint("=" + strings.TrimPrefix(settings.Pin, "v"))
+	}
+	return parsing.ParseConstraint(settings.Version)
+}
+
+// The granularity that all new versions should be written with, if any. Assumes
+// that the config has already been validated by `ReadConfig`.
+func (c *Config) VersionGranularity() parsing.Granularity {
+	g, _ := parsing.ParseGranularity(c.Granularity)
+	return g
+}
+
+// A context for one stage of the run, which ends once that stage has taken
+// longer than `Timeout`. A zero `Timeout` means no limit.
+func (e *Env) Stage() (context.Context, context.CancelFunc) {
+	if e.Timeout <= 0 {
+		return context.WithCancel(e.Ctx)
+	}
+	return context.WithTimeout(e.Ctx, e.Timeout)
+}
+
+// The main host, which Actions are looked up on unless configured otherwise.
+func (c *Config) MainHost() string {
+	if c.Host == "" {
+		return DefaultHost
+	}
+	return c.Host
+}
+
+// The host that the given Action lives on: its own, if it was given by full
+// URL, or otherwise whatever the config says.
+func (c *Config) HostOf(a parsing.Action) string {
+	if h := a.Host(); h != "" {
+		return h
+	}
+	return c.HostFor(a.Repo())
+}
+
+// Identifies the project behind an Action across hosts. Those on github.com
+// are just `owner/repo`; see `Ref.ID`.
+func (c *Config) Key(a parsing.Action) string {
+	return c.Ref(a).ID()
+}
+
+// What to look up for the given Action. Images are looked up on their registry.
+func (c *Config) Ref(a parsing.Action) gitutils.Ref {
+	if a.Kind == parsing.DockerImage {
+		return gitutils.Ref{Host: a.Registry, Owner: a.Owner, Name: a.Name, Pre: c.AllowPrereleases(a.Repo()), Image: true, Prefix: a.Prefix, Variant: a.Suffix}
+	}
+	return gitutils.Ref{Host: c.HostOf(a), Owner: a.Owner, Name: a.Name, Pre: c.AllowPrereleases(a.Repo()), Prefix: a.Prefix}
+}
+
+// Is the given host a Gitea-compatible forge, rather than Github?
+func (c *Config) Gitea(host string) bool {
+	if kind := c.Hosts[host].Kind; kind != "" {
+		return kind == "gitea"
+	}
+	return giteaHosts[host]
+}
+
+// The host that the given `owner/repo` lives on.
+func (c *Config) HostFor(repo string) string {
+	owner := strings.SplitN(repo, "/", 2)[0]
+	if h := c.Actions[repo].Host; h != "" {
+		return h
+	} else if h := c.Owners[owner].Host; h != "" {
+		return h
+	}
+	return c.MainHost()
+}
+
+// The token to use with the given host. `flag` is the one given on the
+// command line, if any, which like `git.token` is only for the main host.
+func (c *Config) TokenFor(host string, flag string) string {
+	if t := c.Hosts[host].Token; t != "" {
+		return t
+	} else if host != c.MainHost() {
+		return ""
+	} else if flag != "" {
+		return flag
+	}
+	return c.Git.Token
+}
+
+// Every host that the config mentions, including the main one.
+func (c *Config) AllHosts() []string {
+	seen := map[string]bool{c.MainHost(): true}
+	hosts := []string{c.MainHost()}
+	add := func(h string) {
+		if h != "" && !seen[h] {
+			seen[h] = true
+			hosts = append(hosts, h)
+		}
+	}
+	for h := range c.Hosts {
+		add(h)
+	}
+	for _, a := range c.Actions {
+		add(a.Host)
+	}
+	for _, o := range c.Owners {
+		add(o.Host)
+	}
+	sort.Strings(hosts[1:])
+	return hosts
+}
+
+// A forge for the given host. Github hosts other than github.com are assumed to
+// be Github Enterprise.
+func HostForge(config *Config, host string, token string) (gitutils.Forge, error) {
+	tok := config.TokenFor(host, token)
+	settings := config.Hosts[host]
+	if config.Gitea(host) {
+		api := settings.API
+		if api == "" {
+			api = "https://" + host + "/api/v1/"
+		}
+		if !strings.HasSuffix(api, "/") {
+			api += "/"
+		}
+		return gitutils.Gitea{API: api, Token: tok}, nil
+	}
+	client, err := HostClient(config, host, tok)
+	if err != nil {
+		return nil, err
+	}
+	return gitutils.Github{Client: client}, nil
+}
+
+// A Github client for the given host, using the given token if there is one.
+func HostClient(config *Config, host string, tok string) (*github.Client, error) {
+	var hc *http.Client
+	if tok != "" {
 		ctx := context.Background()
 		ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: tok})
-		tc := oauth2.NewClient(ctx, ts)
-		return github.NewClient(tc)
+		hc = oauth2.NewClient(ctx, ts)
+	}
+	settings := config.Hosts[host]
+	if host == DefaultHost && settings.API == "" {
+		return github.NewClient(hc), nil
+	}
+	api := settings.API
+	if api == "" {
+		api = "https://" + host + "/api/v3/"
 	}
+	uploads := settings.Uploads
+	if uploads == "" {
+		uploads = api
+	}
+	client, err := github.NewEnterpriseClient(api, uploads, hc)
+	if err != nil {
+		return nil, fmt.Errorf("Bad API URL for %s: %s", host, err)
+	}
+	return client, nil
 }
 
 // Everything necessary for coordinated concurrency and Github lookups.
-func RuntimeEnv(conf *Config, client *github.Client) *Env {
+func RuntimeEnv(ctx context.Context, conf *Config, token string, results *cache.Cache, jobs utils.Pool, timeout time.Duration) *Env {
 	witness := Witness{Seen: make(map[string]bool)}
-	lookups := Lookups{Vers: make(map[string]string)}
+	lookups := Lookups{
+		Vers:   make(map[string]gitutils.Release),
+		Held:   make(map[string]gitutils.Release),
+		Failed: make(map[string]error),
+	}
 	terminal := Terminal{Scan: bufio.NewScanner(os.Stdin)}
-	env := Env{client, &witness, &lookups, &terminal, conf}
+	env := Env{
+		W:       &witness,
+		L:       &lookups,
+		T:       &terminal,
+		Conf:    conf,
+		Cache:   results,
+		Jobs:    jobs,
+		Ctx:     ctx,
+		Timeout: timeout,
+		Token:   token,
+		forges:  make(map[string]gitutils.Forge),
+	}
+	var next gitutils.VersionSource = gitutils.RESTSource{Forge: env.Forge}
+	if conf.Backend == "graphql" {
+		env.GraphQL = gitutils.NewGraphQLSource(next)
+		next = env.GraphQL
+	}
+	env.Source = cache.Source{Cache: results, Next: next}
+	env.Images = cache.Source{Cache: results, Next: gitutils.RegistrySource{}}
 	return &env
 }
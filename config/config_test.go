@@ -0,0 +1,77 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/fosskers/active/gitutils"
+)
+
+func TestManages(t *testing.T) {
+	c := Config{
+		Include: []string{"actions/*", "docker"},
+		Exclude: []string{"actions/secret-*"},
+		Actions: map[string]ActionSettings{"docker/login-action": {Ignore: true}},
+	}
+	cases := map[string]bool{
+		"actions/checkout":         true,
+		"actions/secret-thing":     false,
+		"docker/build-push-action": true,
+		"docker/login-action":      false,
+		"our-org/internal":         false,
+	}
+	for repo, expected := range cases {
+		if actual := c.Manages(repo); actual != expected {
+			t.Errorf("Manages(%s): expected %t", repo, expected)
+		}
+	}
+	if !(&Config{}).Manages("anyone/anything") {
+		t.Errorf("Manages: everything should be managed by default")
+	}
+}
+
+func TestHosts(t *testing.T) {
+	c := Config{
+		Host:    "github.example.com",
+		Hosts:   map[string]HostSettings{"github.com": {Token: "public"}},
+		Git:     Git{Token: "enterprise"},
+		Owners:  map[string]OwnerSettings{"actions": {Host: "github.com"}},
+		Actions: map[string]ActionSettings{"actions/internal": {Host: "github.example.com"}},
+	}
+	hosts := map[string]string{
+		"our-org/setup":    "github.example.com",
+		"actions/checkout": "github.com",
+		"actions/internal": "github.example.com",
+	}
+	for repo, expected := range hosts {
+		if actual := c.HostFor(repo); actual != expected {
+			t.Errorf("HostFor(%s): expected %s, got %s", repo, expected, actual)
+		}
+	}
+	if tok := c.TokenFor("github.com", "flag"); tok != "public" {
+		t.Errorf("TokenFor: expected the host's own token, got %q", tok)
+	}
+	if tok := c.TokenFor("github.example.com", ""); tok != "enterprise" {
+		t.Errorf("TokenFor: expected git.token for the main host, got %q", tok)
+	}
+	if tok := c.TokenFor("other.example.com", "flag"); tok != "" {
+		t.Errorf("TokenFor: other hosts shouldn't get the main token, got %q", tok)
+	}
+
+	client, err := HostClient(&c, "github.example.com", "")
+	if err != nil || client.BaseURL.String() != "https://github.example.com/api/v3/" {
+		t.Errorf("HostClient: expected an Enterprise API URL, got %v, %v", client.BaseURL, err)
+	}
+}
+
+func TestGiteaHosts(t *testing.T) {
+	c := Config{Hosts: map[string]HostSettings{"git.example.com": {Kind: "gitea"}}}
+	for _, host := range []string{"code.forgejo.org", "git.example.com"} {
+		f, err := HostForge(&c, host, "")
+		if g, ok := f.(gitutils.Gitea); err != nil || !ok || g.API != "https://"+host+"/api/v1/" {
+			t.Errorf("HostForge(%s): expected a Gitea forge, got %#v, %v", host, f, err)
+		}
+	}
+	if c.Gitea("github.com") {
+		t.Errorf("Gitea: github.com isn't Gitea")
+	}
+}
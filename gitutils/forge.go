@@ -0,0 +1,42 @@
+package gitutils
+
+import (
+	"context"
+
+	"github.com/google/go-github/v31/github"
+)
+
+// A service that hosts git repositories, like Github or Gitea, through which
+// releases are looked up and PRs are opened.
+type Forge interface {
+	// See `Recent`. Forges that don't support conditional requests ignore
+	// `prev`, and yield no `Validators` of their own.
+	Recent(ctx context.Context, owner, repo string, pre bool, prev Validators) (Release, Validators, error)
+	// See `Releases`.
+	Releases(ctx context.Context, owner, repo string, pre bool, prev Validators) ([]Release, Validators, error)
+	// See `TagCommit`.
+	TagCommit(ctx context.Context, owner, repo, tag string) (string, error)
+	// Open a PR from the given branch into `master`, and return its number.
+	PullRequest(ctx context.Context, owner, repo, branch string) (int, error)
+}
+
+// Github or Github Enterprise, through the REST API.
+type Github struct {
+	Client *github.Client
+}
+
+func (g Github) Recent(ctx context.Context, owner, repo string, pre bool, prev Validators) (Release, Validators, error) {
+	return Recent(ctx, g.Client, owner, repo, pre, prev)
+}
+
+func (g Github) Releases(ctx context.Context, owner, repo string, pre bool, prev Validators) ([]Release, Validators, error) {
+	return Releases(ctx, g.Client, owner, repo, pre, prev)
+}
+
+func (g Github) TagCommit(ctx context.Context, owner, repo, tag string) (string, error) {
+	return TagCommit(ctx, g.Client, owner, repo, tag)
+}
+
+func (g Github) PullRequest(ctx context.Context, owner, repo, branch string) (int, error) {
+	return PullRequest(ctx, g.Client, owner, repo, branch)
+}
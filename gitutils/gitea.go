@@ -0,0 +1,154 @@
+package gitutils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// A Gitea-compatible forge, like Forgejo or Codeberg, through its REST API.
+type Gitea struct {
+	API   string // Like `https://code.forgejo.org/api/v1/`.
+	Token string // Optional, but raises the rate limit of some instances.
+	HTTP  *http.Client
+}
+
+// How many items are asked for per page. A shorter page is taken to be the
+// last one, so instances configured to return fewer only have their newest
+// page seen.
+const giteaPageSize = 50
+
+type giteaRelease struct {
+	TagName     string    `json:"tag_name"`
+	Draft       bool      `json:"draft"`
+	Prerelease  bool      `json:"prerelease"`
+	PublishedAt time.Time `json:"published_at"`
+}
+
+type giteaTag struct {
+	Name   string `json:"name"`
+	Commit struct {
+		SHA string `json:"sha"`
+	} `json:"commit"`
+}
+
+// Gitea has no notion of a "latest" release that is older than what the API
+// reports, so this is just the newest of `Releases`.
+func (g Gitea) Recent(ctx context.Context, owner, repo string, pre bool, prev Validators) (Release, Validators, error) {
+	rs, _, err := g.Releases(ctx, owner, repo, pre, prev)
+	if err != nil {
+		return Release{}, Validators{}, err
+	}
+	return rs[0], Validators{}, nil
+}
+
+func (g Gitea) Releases(ctx context.Context, owner, repo string, pre bool, _ Validators) ([]Release, Validators, error) {
+	rs := make([]Release, 0)
+	for page := 1; ; page++ {
+		rels := make([]giteaRelease, 0)
+		path := fmt.Sprintf("repos/%s/%s/releases?limit=%d&page=%d", owner, repo, giteaPageSize, page)
+		if e0 := g.do(ctx, "GET", path, nil, &rels); e0 != nil {
+			return nil, Validators{}, e0
+		}
+		for _, rel := range rels {
+			if rel.Draft {
+				continue
+			}
+			r := Release{Tag: rel.TagName, Version: versionFormat(rel.TagName), Source: FromRelease, Published: rel.PublishedAt}
+			r.Prerelease = rel.Prerelease || isPrerelease(r.Version)
+			rs = append(rs, r)
+		}
+		if len(rels) < giteaPageSize {
+			break
+		}
+	}
+	if len(rs) == 0 {
+		for page := 1; ; page++ {
+			tags := make([]giteaTag, 0)
+			path := fmt.Sprintf("repos/%s/%s/tags?limit=%d&page=%d", owner, repo, giteaPageSize, page)
+			if e1 := g.do(ctx, "GET", path, nil, &tags); e1 != nil {
+				return nil, Validators{}, e1
+			}
+			for _, t := range tags {
+				if r, ok := fromTag(t.Name, t.Commit.SHA); ok {
+					rs = append(rs, r)
+				}
+			}
+			if len(tags) < giteaPageSize {
+				break
+			}
+		}
+	}
+	sorted, e2 := Sift(owner+"/"+repo, rs, pre)
+	return sorted, Validators{}, e2
+}
+
+func (g Gitea) TagCommit(ctx context.Context, owner, repo, tag string) (string, error) {
+	var t giteaTag
+	path := fmt.Sprintf("repos/%s/%s/tags/%s", owner, repo, url.PathEscape(tag))
+	if err := g.do(ctx, "GET", path, nil, &t); err != nil {
+		return "", err
+	}
+	return t.Commit.SHA, nil
+}
+
+func (g Gitea) PullRequest(ctx context.Context, owner, repo, branch string) (int, error) {
+	body := map[string]string{
+		"title": "Github CI Action Updates",
+		"head":  branch,
+		"base":  "master",
+		"body":  "This PR was opened automatically by the `active` tool.",
+	}
+	var pr struct {
+		Number int `json:"number"`
+	}
+	if err := g.do(ctx, "POST", fmt.Sprintf("repos/%s/%s/pulls", owner, repo), body, &pr); err != nil {
+		return 0, err
+	}
+	return pr.Number, nil
+}
+
+// Make a request against the API, sending `body` and decoding the response
+// into `v` as JSON.
+func (g Gitea) do(ctx context.Context, method, path string, body interface{}, v interface{}) error {
+	ctx, cancel := requestContext(ctx)
+	defer cancel()
+	var reader io.Reader
+	if body != nil {
+		bs, e0 := json.Marshal(body)
+		if e0 != nil {
+			return e0
+		}
+		reader = bytes.NewReader(bs)
+	}
+	req, e1 := http.NewRequest(method, g.API+path, reader)
+	if e1 != nil {
+		return e1
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if g.Token != "" {
+		req.Header.Set("Authorization", "token "+g.Token)
+	}
+	client := g.HTTP
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, e2 := client.Do(req)
+	if e2 != nil {
+		return e2
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s %s: %s", method, req.URL, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -2,11 +2,14 @@ package gitutils
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
+	"github.com/fosskers/active/parsing"
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
@@ -15,19 +18,292 @@ import (
 	"github.com/google/go-github/v31/github"
 )
 
+// Where the version of a `Release` was found.
+type Source string
+
+const (
+	FromRelease  Source = "release"  // A published Github Release.
+	FromTag      Source = "tag"      // A plain git tag, for projects without Releases.
+	FromRegistry Source = "registry" // The tag of a container image.
+)
+
+// The most recent release of some Github project.
+type Release struct {
+	Tag        string    `json:"tag"`               // The full tag name, like `v1.2.3`.
+	Version    string    `json:"version,omitempty"` // The tag name without its prefix, like the `v` of `v1.2.3`.
+	SHA        string    `json:"sha,omitempty"`     // The commit that the tag points to, if it has been resolved.
+	Source     Source    `json:"source,omitempty"`
+	Prerelease bool      `json:"prerelease,omitempty"` // Either marked as such on Github, or evident from the version.
+	Published  time.Time `json:"published"`            // Unknown (zero) for plain tags.
+}
+
+// Was this release published before the given time? Releases with an unknown
+// publish time never were, since their age can't be vouched for.
+func (r Release) PublishedBefore(cutoff time.Time) bool {
+	return !r.Published.IsZero() && r.Published.Before(cutoff)
+}
+
+// Response headers from an earlier request, which allow a later one for the
+// same thing to be answered with `304 Not Modified`. Github doesn't count such
+// answers against the rate limit.
+type Validators struct {
+	URL          string `json:"url,omitempty"` // The request that they belong to.
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+// Nothing has changed since the given `Validators` were issued, so whatever
+// was fetched alongside them is still current.
+var ErrNotModified = errors.New("Not modified.")
+
 // Given an activated client and a Github project, look up the version of its
-// most recent release.
-func Recent(client *github.Client, owner, repo string) (string, error) {
-	rel, _, err := client.Repositories.GetLatestRelease(context.Background(), owner, repo)
+// most recent release. Many projects only push tags and never publish a
+// Release, so for those we fall back to the highest version among their tags.
+// Prereleases are only considered if `pre` is set, and drafts never are.
+//
+// If `prev` came from an earlier lookup, the request is made conditionally,
+// and `ErrNotModified` is yielded if that earlier result still stands. If that
+// result came from the fallback, the fallback is asked again directly.
+func Recent(ctx context.Context, client *github.Client, owner, repo string, pre bool, prev Validators) (Release, Validators, error) {
+	fallback := prev.URL == releasesURL(owner, repo, 1) || prev.URL == tagsURL(owner, repo, 1)
+	if !pre && !fallback {
+		rel := new(github.RepositoryRelease)
+		url := fmt.Sprintf("repos/%v/%v/releases/latest", owner, repo)
+		_, vs, err := get(ctx, client, url, prev, rel)
+		if err == ErrNotModified {
+			return Release{}, prev, err
+		} else if err != nil && !notFound(err) {
+			return Release{}, Validators{}, err
+		}
+		// Github never marks a prerelease as the latest, but a project's own
+		// version numbering might still disagree.
+		if err == nil {
+			if r := fromRelease(rel); !r.Prerelease {
+				return r, vs, nil
+			}
+		}
+	}
+	rs, vs, err := Releases(ctx, client, owner, repo, pre, prev)
 	if err != nil {
-		return "", err
+		return Release{}, vs, err
 	}
-	return versionFormat(rel.GetTagName()), nil
+	return rs[0], vs, nil
+}
+
+// Every release of a Github project, from newest to oldest, with the same
+// fallback to tags and filtering of prereleases as `Recent`. Releases whose
+// tags aren't versions are dropped. Yields an error if nothing remains.
+// Conditional requests are made just as in `Recent`.
+func Releases(ctx context.Context, client *github.Client, owner, repo string, pre bool, prev Validators) ([]Release, Validators, error) {
+	// Projects that only had tags before are asked about those first, since
+	// new Releases would come with new tags anyway.
+	var tags []Release
+	var tvs Validators
+	if prev.URL == tagsURL(owner, repo, 1) {
+		ts, vs, e0 := allTags(ctx, client, owner, repo, prev)
+		if e0 != nil {
+			return nil, vs, e0
+		}
+		tags, tvs = ts, vs
+	}
+	rs, vs, e1 := allReleases(ctx, client, owner, repo, prev)
+	if e1 != nil {
+		return nil, vs, e1
+	}
+	if len(rs) == 0 && tags == nil {
+		ts, vs, e2 := allTags(ctx, client, owner, repo, prev)
+		if e2 != nil {
+			return nil, vs, e2
+		}
+		tags, tvs = ts, vs
+	}
+	if len(rs) == 0 {
+		rs, vs = tags, tvs
+	}
+	sorted, e3 := Sift(owner+"/"+repo, rs, pre)
+	if e3 != nil {
+		return nil, Validators{}, e3
+	}
+	return sorted, vs, nil
+}
+
+func releasesURL(owner, repo string, page int) string {
+	return fmt.Sprintf("repos/%v/%v/releases?per_page=100&page=%d", owner, repo, page)
+}
+
+func tagsURL(owner, repo string, page int) string {
+	return fmt.Sprintf("repos/%v/%v/tags?per_page=100&page=%d", owner, repo, page)
 }
 
-// Strip the `v` from the beginning of the tag name.
+// Sort the releases of the given `owner/repo` from newest to oldest, dropping
+// those whose tags aren't versions, and prereleases unless `pre` is set.
+// Yields an error if nothing remains.
+func Sift(repo string, rs []Release, pre bool) ([]Release, error) {
+	sorted := candidates(rs, pre)
+	if len(sorted) == 0 {
+		return nil, fmt.Errorf("No suitable releases or version tags found for %s.", repo)
+	}
+	return sorted, nil
+}
+
+// Make a GET request against the Github API, decoding the response into `v`.
+// The request is conditional if `prev` belongs to the same URL. The
+// `Validators` yielded are those of this response.
+func get(ctx context.Context, client *github.Client, url string, prev Validators, v interface{}) (*github.Response, Validators, error) {
+	req, e0 := client.NewRequest("GET", url, nil)
+	if e0 != nil {
+		return nil, Validators{}, e0
+	}
+	if prev.URL == url {
+		if prev.ETag != "" {
+			req.Header.Set("If-None-Match", prev.ETag)
+		}
+		if prev.LastModified != "" {
+			req.Header.Set("If-Modified-Since", prev.LastModified)
+		}
+	}
+	resp, e1 := withBackoff(ctx, func(ctx context.Context) (*github.Response, error) {
+		return client.Do(ctx, req, v)
+	})
+	if resp != nil && resp.StatusCode == 304 {
+		return resp, prev, ErrNotModified
+	} else if e1 != nil {
+		return resp, Validators{}, e1
+	}
+	vs := Validators{URL: url, ETag: resp.Header.Get("ETag"), LastModified: resp.Header.Get("Last-Modified")}
+	return resp, vs, nil
+}
+
+// All of a project's published (i.e. non-draft) Releases. Only the first page
+// is requested conditionally, since new Releases always appear there.
+func allReleases(ctx context.Context, client *github.Client, owner, repo string, prev Validators) ([]Release, Validators, error) {
+	rs := make([]Release, 0)
+	var first Validators
+	for page := 1; ; {
+		rels := make([]*github.RepositoryRelease, 0)
+		url := releasesURL(owner, repo, page)
+		resp, vs, err := get(ctx, client, url, prev, &rels)
+		if err != nil {
+			return nil, vs, err
+		}
+		if page == 1 {
+			first = vs
+		}
+		for _, rel := range rels {
+			if !rel.GetDraft() {
+				rs = append(rs, fromRelease(rel))
+			}
+		}
+		if resp.NextPage == 0 {
+			return rs, first, nil
+		}
+		page = resp.NextPage
+	}
+}
+
+func fromRelease(rel *github.RepositoryRelease) Release {
+	tag := rel.GetTagName()
+	r := Release{Tag: tag, Version: versionFormat(tag), Source: FromRelease, Published: rel.GetPublishedAt().Time}
+	r.Prerelease = rel.GetPrerelease() || isPrerelease(r.Version)
+	return r
+}
+
+// All of a project's tags, as if they were Releases. Tags of every prefix are
+// kept, so that lookups can choose the kind they're after; see `Ref.Accepts`.
+func allTags(ctx context.Context, client *github.Client, owner, repo string, prev Validators) ([]Release, Validators, error) {
+	rs := make([]Release, 0)
+	var first Validators
+	for page := 1; ; {
+		tags := make([]*github.RepositoryTag, 0)
+		url := tagsURL(owner, repo, page)
+		resp, vs, err := get(ctx, client, url, prev, &tags)
+		if err != nil {
+			return nil, vs, err
+		}
+		if page == 1 {
+			first = vs
+		}
+		for _, t := range tags {
+			if r, ok := fromTag(t.GetName(), t.GetCommit().GetSHA()); ok {
+				rs = append(rs, r)
+			}
+		}
+		if resp.NextPage == 0 {
+			return rs, first, nil
+		}
+		page = resp.NextPage
+	}
+}
+
+func fromTag(tag string, sha string) (Release, bool) {
+	_, version := parsing.SplitPrefix(tag)
+	if version == "" {
+		return Release{}, false
+	}
+	return Release{Tag: tag, Version: version, SHA: sha, Source: FromTag, Prerelease: isPrerelease(version)}, true
+}
+
+// Sort some releases from newest to oldest by their semantic versions.
+// Prereleases are dropped unless `pre` is set, as are unreadable versions.
+func candidates(rs []Release, pre bool) []Release {
+	vs := make(map[string]parsing.Semver)
+	kept := make([]Release, 0, len(rs))
+	for _, r := range rs {
+		v, err := parsing.ParseSemver(r.Version)
+		if err != nil || (r.Prerelease && !pre) {
+			continue
+		}
+		vs[r.Tag] = v
+		kept = append(kept, r)
+	}
+	sort.SliceStable(kept, func(i, j int) bool {
+		return vs[kept[i].Tag].Compare(vs[kept[j].Tag]) > 0
+	})
+	return kept
+}
+
+func isPrerelease(version string) bool {
+	v, err := parsing.ParseSemver(version)
+	return err == nil && v.Pre != ""
+}
+
+// Did a request fail because the thing asked for doesn't exist?
+func notFound(err error) bool {
+	e, ok := err.(*github.ErrorResponse)
+	return ok && e.Response != nil && e.Response.StatusCode == 404
+}
+
+// Find the commit that a given tag points to. Annotated tags are followed to
+// the commit they annotate.
+func TagCommit(ctx context.Context, client *github.Client, owner, repo, tag string) (string, error) {
+	var ref *github.Reference
+	_, e0 := withBackoff(ctx, func(ctx context.Context) (resp *github.Response, err error) {
+		ref, resp, err = client.Git.GetRef(ctx, owner, repo, "tags/"+tag)
+		return
+	})
+	if e0 != nil {
+		return "", e0
+	}
+	obj := ref.GetObject()
+	if obj.GetType() != "tag" {
+		return obj.GetSHA(), nil
+	}
+	var annotated *github.Tag
+	_, e1 := withBackoff(ctx, func(ctx context.Context) (resp *github.Response, err error) {
+		annotated, resp, err = client.Git.GetTag(ctx, owner, repo, obj.GetSHA())
+		return
+	})
+	if e1 != nil {
+		return "", e1
+	}
+	return annotated.GetObject().GetSHA(), nil
+}
+
+// Strip the prefix from the beginning of the tag name, whatever it is. Yields
+// the empty string for tags that aren't versions.
 func versionFormat(version string) string {
-	return version[1:]
+	_, v := parsing.SplitPrefix(version)
+	return v
 }
 
 // Switch to a given branch.
@@ -71,6 +347,29 @@ func CheckoutCreate(r *git.Repository, branch string) error {
 	return nil
 }
 
+// Switch back to `master`, discarding any changes, and delete the given branch
+// if nothing was ever committed to it. This undoes `CheckoutCreate`.
+func Restore(r *git.Repository, branch string) error {
+	w, e0 := r.Worktree()
+	if e0 != nil {
+		return e0
+	}
+	e1 := w.Checkout(&git.CheckoutOptions{Branch: plumbing.Master, Force: true})
+	if e1 != nil {
+		return e1
+	}
+	master, e2 := r.Reference(plumbing.Master, true)
+	if e2 != nil {
+		return e2
+	}
+	name := plumbing.NewBranchReferenceName(branch)
+	ref, e3 := r.Reference(name, true)
+	if e3 != nil || ref.Hash() != master.Hash() {
+		return nil // Already gone, or has work on it worth keeping.
+	}
+	return r.Storer.RemoveReference(name)
+}
+
 // Commit the changes in some given filepaths.
 func Commit(r *git.Repository, name string, email string, files []string) error {
 	w, e0 := r.Worktree()
@@ -94,10 +393,10 @@ func Commit(r *git.Repository, name string, email string, files []string) error
 }
 
 // Push the given branch.
-func Push(r *git.Repository, remote string, branch string, user string, token string) error {
+func Push(ctx context.Context, r *git.Repository, remote string, branch string, user string, token string) error {
 	src := filepath.Join("refs/heads/", branch)
 	spec := config.RefSpec(src + ":" + src)
-	return r.Push(&git.PushOptions{
+	return r.PushContext(ctx, &git.PushOptions{
 		RemoteName: remote,
 		RefSpecs:   []config.RefSpec{spec},
 		Auth:       &http.BasicAuth{Username: user, Password: token},
@@ -105,8 +404,8 @@ func Push(r *git.Repository, remote string, branch string, user string, token st
 }
 
 // Pull the `master` branch.
-func PullMaster(w *git.Worktree, remote string, user string, token string) error {
-	return w.Pull(&git.PullOptions{
+func PullMaster(ctx context.Context, w *git.Worktree, remote string, user string, token string) error {
+	return w.PullContext(ctx, &git.PullOptions{
 		RemoteName:    remote,
 		ReferenceName: plumbing.Master,
 		SingleBranch:  true,
@@ -115,7 +414,7 @@ func PullMaster(w *git.Worktree, remote string, user string, token string) error
 }
 
 // Open a pull request, and return its number.
-func PullRequest(c *github.Client, owner string, repo string, branch string) (int, error) {
+func PullRequest(ctx context.Context, c *github.Client, owner string, repo string, branch string) (int, error) {
 	new := &github.NewPullRequest{
 		Title:               github.String("Github CI Action Updates"),
 		Head:                github.String(branch),
@@ -123,7 +422,9 @@ func PullRequest(c *github.Client, owner string, repo string, branch string) (in
 		Body:                github.String("This PR was opened automatically by the `active` tool."),
 		MaintainerCanModify: github.Bool(true),
 	}
-	pr, _, e0 := c.PullRequests.Create(context.Background(), owner, repo, new)
+	rctx, cancel := requestContext(ctx)
+	defer cancel()
+	pr, _, e0 := c.PullRequests.Create(rctx, owner, repo, new)
 	if e0 != nil {
 		return 0, e0
 	}
@@ -150,46 +451,86 @@ func ChooseRemote(rs []*git.Remote) *git.Remote {
 }
 
 // Fetch or create an HTTP-based remote that we can used to push via the given
-// Github API token. Yields the name of the remote, as well as the owner of the
-// repo.
-func PushableRemote(repo *git.Repository) (string, string, error) {
+// Github API token. Yields the name of the remote, as well as the host and
+// owner of the repo.
+func PushableRemote(repo *git.Repository) (string, string, string, error) {
 	rs, e0 := repo.Remotes()
 	if e0 != nil {
-		return "", "", e0
+		return "", "", "", e0
 	}
 
 	chosen := ChooseRemote(rs)
 	if chosen == nil {
-		return "", "", fmt.Errorf("No remotes found.")
+		return "", "", "", fmt.Errorf("No remotes found.")
 	}
 
 	rc := chosen.Config()
 	if rc == nil {
-		return "", "", fmt.Errorf("Couldn't fetch RemoteConfig.")
+		return "", "", "", fmt.Errorf("Couldn't fetch RemoteConfig.")
 	}
 
 	if len(rc.URLs) == 0 {
-		return "", "", fmt.Errorf("Given remote had no URLs!")
+		return "", "", "", fmt.Errorf("Given remote had no URLs!")
 	}
 
-	// We don't need to create a new remote; the one given uses HTTPS already.
-	if rc.URLs[0][0:5] == "https" {
-		owner := strings.Split(rc.URLs[0][8:], "/")[1]
-		return rc.Name, owner, nil
+	host, owner, path, e1 := ParseRemote(rc.URLs[0])
+	if e1 != nil {
+		return "", "", "", e1
 	}
 
-	base := "https://" + strings.ReplaceAll(rc.URLs[0][4:], ":", "/")
-	owner := strings.Split(base[8:], "/")[1]
+	// We don't need to create a new remote; the one given uses HTTPS already.
+	if strings.HasPrefix(rc.URLs[0], "https://") {
+		return rc.Name, host, owner, nil
+	}
 
 	new := config.RemoteConfig{
 		Name: "active",
-		URLs: []string{base},
+		URLs: []string{"https://" + host + "/" + path},
 	}
 
-	_, e1 := repo.CreateRemote(&new)
-	if e1 != nil {
-		return "", "", e1
+	_, e2 := repo.CreateRemote(&new)
+	if e2 != nil {
+		return "", "", "", e2
 	}
 
-	return "active", owner, nil
+	return "active", host, owner, nil
+}
+
+// Split a remote's URL into its host, the owner of the repo, and the repo's
+// full path on that host. Any of the forms that git understands will do:
+//
+//	https://github.example.com/owner/repo.git
+//	ssh://git@github.example.com:2222/owner/repo.git
+//	git@github.example.com:owner/repo.git
+func ParseRemote(remote string) (string, string, string, error) {
+	var host, path string
+	if i := strings.Index(remote, "://"); i >= 0 {
+		rest := remote[i+3:]
+		slash := strings.Index(rest, "/")
+		if slash < 0 {
+			return "", "", "", fmt.Errorf("No repository given in %q.", remote)
+		}
+		host, path = rest[:slash], rest[slash+1:]
+		// Credentials and ports aren't part of the host's name.
+		if at := strings.LastIndex(host, "@"); at >= 0 {
+			host = host[at+1:]
+		}
+		if colon := strings.Index(host, ":"); colon >= 0 {
+			host = host[:colon]
+		}
+	} else {
+		colon := strings.Index(remote, ":")
+		if colon < 0 {
+			return "", "", "", fmt.Errorf("Unrecognized remote URL %q.", remote)
+		}
+		host, path = remote[:colon], remote[colon+1:]
+		if at := strings.LastIndex(host, "@"); at >= 0 {
+			host = host[at+1:]
+		}
+	}
+	owner := strings.SplitN(path, "/", 2)[0]
+	if host == "" || owner == "" {
+		return "", "", "", fmt.Errorf("Unrecognized remote URL %q.", remote)
+	}
+	return host, owner, path, nil
 }
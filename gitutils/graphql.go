@@ -0,0 +1,198 @@
+package gitutils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v31/github"
+)
+
+// How many projects are asked about in a single GraphQL query. Github limits
+// the total number of nodes that one query may return.
+const batchSize = 25
+
+// How many of each project's newest Releases and tags are considered by a
+// batched lookup. Unlike `Releases`, older ones are never seen.
+const (
+	batchReleases = 50
+	batchTags     = 100
+)
+
+type gqlRelease struct {
+	TagName      string
+	IsPrerelease bool
+	IsDraft      bool
+	PublishedAt  time.Time
+	TagCommit    *struct{ Oid string }
+}
+
+type gqlTag struct {
+	Name   string
+	Target struct {
+		Oid    string
+		Target *struct{ Oid string } // Only present for annotated tags.
+	}
+}
+
+type gqlRepository struct {
+	Releases struct{ Nodes []gqlRelease }
+	Refs     struct{ Nodes []gqlTag }
+}
+
+type gqlResponse struct {
+	Data   map[string]*gqlRepository
+	Errors []struct {
+		Message string
+		Path    []interface{}
+	}
+}
+
+// Look up the Releases of many Github projects at once through the GraphQL
+// API, which would otherwise take a REST request each. Projects without
+// Releases yield their tags instead, as in `Releases`, but nothing is filtered
+// or sorted yet; see `Sift`. Results and failures are keyed by `owner/repo`.
+// The GraphQL API can only be used with a token.
+func BatchReleases(ctx context.Context, client *github.Client, repos []string) (map[string][]Release, map[string]error) {
+	found := make(map[string][]Release)
+	errs := make(map[string]error)
+	for start := 0; start < len(repos); start += batchSize {
+		end := start + batchSize
+		if end > len(repos) {
+			end = len(repos)
+		}
+		chunk := repos[start:end]
+		resp, e0 := queryRepos(ctx, client, chunk)
+		if e0 != nil {
+			for _, repo := range chunk {
+				errs[repo] = e0
+			}
+			continue
+		}
+		// Errors that don't belong to any one project, like a malformed query,
+		// belong to all of them.
+		general := fmt.Errorf("No such repository.")
+		for _, e := range resp.Errors {
+			if i, ok := aliasIndex(e.Path); ok && i < len(chunk) {
+				errs[chunk[i]] = fmt.Errorf("%s", e.Message)
+			} else {
+				general = fmt.Errorf("%s", e.Message)
+			}
+		}
+		for i, repo := range chunk {
+			r := resp.Data[alias(i)]
+			if r == nil {
+				if _, failed := errs[repo]; !failed {
+					errs[repo] = general
+				}
+				continue
+			}
+			found[repo] = fromRepository(r)
+		}
+	}
+	return found, errs
+}
+
+func queryRepos(ctx context.Context, client *github.Client, repos []string) (*gqlResponse, error) {
+	var q strings.Builder
+	q.WriteString("query {\n")
+	for i, repo := range repos {
+		parts := strings.SplitN(repo, "/", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Expected an `owner/repo`, got %q.", repo)
+		}
+		fmt.Fprintf(&q, "  %s: repository(owner: %s, name: %s) {%s}\n", alias(i), quote(parts[0]), quote(parts[1]), repoFields)
+	}
+	q.WriteString("}")
+
+	body := map[string]string{"query": q.String()}
+	resp := new(gqlResponse)
+	_, err := withBackoff(ctx, func(ctx context.Context) (*github.Response, error) {
+		// The request body can only be read once, so every attempt needs a
+		// fresh request.
+		req, err := client.NewRequest("POST", graphqlURL(client), body)
+		if err != nil {
+			return nil, err
+		}
+		return client.Do(ctx, req, resp)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Github Enterprise serves GraphQL beside its REST API, rather than within it.
+func graphqlURL(client *github.Client) string {
+	if strings.HasSuffix(client.BaseURL.Path, "/api/v3/") {
+		return "../graphql"
+	}
+	return "graphql"
+}
+
+var repoFields = fmt.Sprintf(`
+    releases(first: %d, orderBy: {field: CREATED_AT, direction: DESC}) {
+      nodes { tagName isPrerelease isDraft publishedAt tagCommit { oid } }
+    }
+    refs(refPrefix: "refs/tags/", first: %d, orderBy: {field: TAG_COMMIT_DATE, direction: DESC}) {
+      nodes { name target { oid ... on Tag { target { oid } } } }
+    }
+  `, batchReleases, batchTags)
+
+func fromRepository(r *gqlRepository) []Release {
+	rs := make([]Release, 0)
+	for _, rel := range r.Releases.Nodes {
+		if rel.IsDraft {
+			continue
+		}
+		version := versionFormat(rel.TagName)
+		release := Release{Tag: rel.TagName, Version: version, Source: FromRelease, Published: rel.PublishedAt}
+		release.Prerelease = rel.IsPrerelease || isPrerelease(version)
+		if rel.TagCommit != nil {
+			release.SHA = rel.TagCommit.Oid
+		}
+		rs = append(rs, release)
+	}
+	if len(rs) > 0 {
+		return rs
+	}
+	for _, t := range r.Refs.Nodes {
+		sha := t.Target.Oid
+		if t.Target.Target != nil {
+			sha = t.Target.Target.Oid
+		}
+		if release, ok := fromTag(t.Name, sha); ok {
+			rs = append(rs, release)
+		}
+	}
+	return rs
+}
+
+// Each project in a query is given a name, so that its result can be found.
+func alias(i int) string {
+	return fmt.Sprintf("r%d", i)
+}
+
+// Which project of a query an error belongs to, judging by its path.
+func aliasIndex(path []interface{}) (int, bool) {
+	if len(path) == 0 {
+		return 0, false
+	}
+	name, ok := path[0].(string)
+	if !ok {
+		return 0, false
+	}
+	var i int
+	if _, err := fmt.Sscanf(name, "r%d", &i); err != nil {
+		return 0, false
+	}
+	return i, true
+}
+
+// GraphQL strings are escaped just like JSON ones.
+func quote(s string) string {
+	bytes, _ := json.Marshal(s)
+	return string(bytes)
+}
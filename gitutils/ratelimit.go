@@ -0,0 +1,107 @@
+package gitutils
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/go-github/v31/github"
+)
+
+// How long we're willing to wait for Github's primary rate limit to reset. If
+// it resets any later than this, requests fail instead.
+var MaxPause = time.Minute
+
+// How many times a request is retried after hitting a rate limit.
+const retries = 3
+
+// How long to back off from the secondary rate limit when Github doesn't say,
+// doubling with every retry.
+const backoff = time.Minute
+
+// How long a single request to Github may take before it's abandoned. Zero
+// (or less) means no limit.
+var RequestTimeout = 30 * time.Second
+
+// Limit a single request to `RequestTimeout`, if there is a limit.
+func requestContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if RequestTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, RequestTimeout)
+}
+
+// Wait for the given duration, unless the context ends first. Replaced in
+// tests, so that they don't actually wait.
+var sleep = func(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Make a request, waiting out Github's rate limits when that's reasonable. The
+// primary limit is waited for only if it resets within `MaxPause`, while the
+// secondary limit, which guards against bursts of requests, is backed off from
+// for as long as Github asks. Each attempt is given `RequestTimeout` to finish.
+func withBackoff(ctx context.Context, call func(context.Context) (*github.Response, error)) (*github.Response, error) {
+	for attempt := 0; ; attempt++ {
+		rctx, cancel := requestContext(ctx)
+		resp, err := call(rctx)
+		cancel()
+		if attempt >= retries {
+			return resp, err
+		}
+		var wait time.Duration
+		switch e := err.(type) {
+		case *github.RateLimitError:
+			wait = time.Until(e.Rate.Reset.Time) + time.Second // Github's clock may differ slightly from ours.
+			if wait > MaxPause {
+				return resp, err
+			}
+		case *github.AbuseRateLimitError:
+			wait = backoff << uint(attempt)
+			if e.RetryAfter != nil {
+				wait = *e.RetryAfter
+			}
+		default:
+			return resp, err
+		}
+		if e := sleep(ctx, wait); e != nil {
+			return resp, e
+		}
+	}
+}
+
+// Was this error caused by either of Github's rate limits?
+func RateLimited(err error) bool {
+	switch err.(type) {
+	case *github.RateLimitError, *github.AbuseRateLimitError:
+		return true
+	default:
+		return false
+	}
+}
+
+// A short, human-readable reason for a failed lookup.
+func Explain(err error) string {
+	switch e := err.(type) {
+	case *github.RateLimitError:
+		return fmt.Sprintf("The rate limit of %d requests was exceeded. It resets at %s.", e.Rate.Limit, e.Rate.Reset.Format("15:04"))
+	case *github.AbuseRateLimitError:
+		return "Github's secondary rate limit was exceeded. Try again in a few minutes."
+	}
+	if err == context.DeadlineExceeded {
+		return "Github took too long to answer."
+	} else if err == context.Canceled {
+		return "Cancelled."
+	}
+	if notFound(err) {
+		return "No such repository, or it isn't visible with the given token."
+	}
+	return err.Error()
+}
@@ -0,0 +1,184 @@
+package gitutils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/fosskers/active/parsing"
+)
+
+// Looks up the tags of container images through the OCI distribution API,
+// which Docker Hub, GHCR, and most other registries serve. Only public images
+// can be seen. Refs name the registry as their `Host`, and the image's path
+// within it as their `Owner` and `Name`.
+type RegistrySource struct {
+	HTTP *http.Client
+	// The API of each registry by host, for those not served from
+	// `https://<host>`.
+	Endpoints map[string]string
+}
+
+// Registries whose API lives elsewhere than their name suggests.
+var registryEndpoints = map[string]string{parsing.DockerHub: "https://registry-1.docker.io"}
+
+// How many tags are asked for per page. Registries may well return fewer.
+const registryPageSize = 1000
+
+var challengeRx = regexp.MustCompile(`(\w+)="([^"]*)"`)
+var linkRx = regexp.MustCompile(`<([^>]+)>\s*;\s*rel="?next"?`)
+
+func (s RegistrySource) LookupLatest(ctx context.Context, ref Ref) (Release, error) {
+	rs, err := s.LookupAll(ctx, ref)
+	if err != nil {
+		return Release{}, err
+	}
+	return rs[0], nil
+}
+
+// Only tags that share the `Prefix` and `Variant` of the ref are considered,
+// so that `node:16-alpine` is only ever offered other `-alpine` tags, and
+// `python:3.12` is never offered `windowsservercore-1809`.
+func (s RegistrySource) LookupAll(ctx context.Context, ref Ref) ([]Release, error) {
+	tags, e0 := s.tags(ctx, ref)
+	if e0 != nil {
+		return nil, e0
+	}
+	rs := make([]Release, 0)
+	for _, tag := range tags {
+		if _, version, _ := parsing.SplitImageTag(tag); ref.Accepts(tag) {
+			rs = append(rs, Release{Tag: tag, Version: version, Source: FromRegistry})
+		}
+	}
+	return Sift(ref.ID(), rs, ref.Pre)
+}
+
+func (s RegistrySource) endpoint(host string) string {
+	if e, found := s.Endpoints[host]; found {
+		return strings.TrimSuffix(e, "/")
+	} else if e, found := registryEndpoints[host]; found {
+		return e
+	}
+	// Like Docker itself, assume that local registries don't use TLS.
+	if name := strings.Split(host, ":")[0]; name == "localhost" || name == "127.0.0.1" {
+		return "http://" + host
+	}
+	return "https://" + host
+}
+
+// Every tag of an image, following as many pages as the registry gives. Most
+// registries want a token even for public images, which is fetched as soon as
+// they say so.
+func (s RegistrySource) tags(ctx context.Context, ref Ref) ([]string, error) {
+	next := fmt.Sprintf("%s/v2/%s/tags/list?n=%d", s.endpoint(ref.Host), ref.path(), registryPageSize)
+	token := ""
+	tags := make([]string, 0)
+	for next != "" {
+		var page struct {
+			Tags []string `json:"tags"`
+		}
+		resp, e0 := s.get(ctx, next, token, &page)
+		if resp != nil && resp.StatusCode == 401 && token == "" {
+			tok, e1 := s.token(ctx, resp.Header.Get("WWW-Authenticate"))
+			if e1 != nil {
+				return nil, e1
+			}
+			token = tok
+			continue
+		} else if resp != nil && resp.StatusCode == 404 {
+			return nil, fmt.Errorf("No such image as %s.", ref.ID())
+		} else if e0 != nil {
+			return nil, e0
+		}
+		tags = append(tags, page.Tags...)
+		next = nextPage(next, resp.Header.Get("Link"))
+	}
+	return tags, nil
+}
+
+// Ask the registry's auth server for an anonymous token, as described by the
+// challenge of a `401` response, like:
+//
+//	Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:library/node:pull"
+func (s RegistrySource) token(ctx context.Context, challenge string) (string, error) {
+	if !strings.HasPrefix(strings.ToLower(challenge), "bearer ") {
+		return "", fmt.Errorf("The registry asked for unsupported authentication: %q.", challenge)
+	}
+	realm := ""
+	params := url.Values{}
+	for _, m := range challengeRx.FindAllStringSubmatch(challenge, -1) {
+		if m[1] == "realm" {
+			realm = m[2]
+		} else {
+			params.Set(m[1], m[2])
+		}
+	}
+	if realm == "" {
+		return "", fmt.Errorf("The registry didn't say where to get a token.")
+	}
+	var t struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if _, err := s.get(ctx, realm+"?"+params.Encode(), "", &t); err != nil {
+		return "", err
+	}
+	if t.Token == "" {
+		t.Token = t.AccessToken
+	}
+	if t.Token == "" {
+		return "", fmt.Errorf("The registry gave no token.")
+	}
+	return t.Token, nil
+}
+
+// Make a GET request, decoding the response into `v`. The response is yielded
+// even for failures, so that its status and headers can be checked.
+func (s RegistrySource) get(ctx context.Context, url string, token string, v interface{}) (*http.Response, error) {
+	ctx, cancel := requestContext(ctx)
+	defer cancel()
+	req, e0 := http.NewRequest("GET", url, nil)
+	if e0 != nil {
+		return nil, e0
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	client := s.HTTP
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, e1 := client.Do(req)
+	if e1 != nil {
+		return nil, e1
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return resp, fmt.Errorf("GET %s: %s", req.URL, resp.Status)
+	}
+	return resp, json.NewDecoder(resp.Body).Decode(v)
+}
+
+// The next page of results named by a `Link` header, which is usually given
+// relative to the current one. Yields the empty string on the last page.
+func nextPage(current string, link string) string {
+	m := linkRx.FindStringSubmatch(link)
+	if m == nil {
+		return ""
+	}
+	base, e0 := url.Parse(current)
+	if e0 != nil {
+		return ""
+	}
+	next, e1 := base.Parse(m[1])
+	if e1 != nil {
+		return ""
+	}
+	return next.String()
+}
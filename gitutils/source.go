@@ -0,0 +1,293 @@
+package gitutils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+
+	"github.com/fosskers/active/parsing"
+	"github.com/google/go-github/v31/github"
+)
+
+// A project whose versions are being looked up.
+type Ref struct {
+	Host  string // Where it lives, like `github.com`.
+	Owner string
+	Name  string
+	Pre   bool // Whether prereleases may be offered.
+	// A container image on a registry, rather than a project on a forge.
+	Image bool
+	// What tags must begin with, like `v` or `release-`. A project might
+	// version several things at once, and only one of them is wanted.
+	Prefix string
+	// For images, the suffix that their tags must share, like `-alpine`.
+	Variant string
+}
+
+// Identifies the project across hosts. Those on github.com are just
+// `owner/repo`. Tags of a form other than the usual `v1.2.3` are noted, like
+// `owner/repo@release-*` or `docker.io/library/node:*-alpine`.
+func (r Ref) ID() string {
+	id := r.Host + "/" + r.path()
+	if r.Host == "" || r.Host == "github.com" {
+		id = r.path()
+	}
+	if r.Image && (r.Prefix != "" || r.Variant != "") {
+		id += ":" + r.Prefix + "*" + r.Variant
+	} else if !r.Image && r.Prefix != "v" {
+		id += "@" + r.Prefix + "*"
+	}
+	return id
+}
+
+// Where the project lives on its host. Images may have no `Owner`, like
+// `localhost:5000/app`.
+func (r Ref) path() string {
+	if r.Owner == "" {
+		return r.Name
+	}
+	return r.Owner + "/" + r.Name
+}
+
+// Is the given tag a version of the form this ref is after?
+func (r Ref) Accepts(tag string) bool {
+	if r.Image {
+		prefix, version, suffix := parsing.SplitImageTag(tag)
+		return version != "" && prefix == r.Prefix && suffix == r.Variant
+	}
+	prefix, version := parsing.SplitPrefix(tag)
+	return version != "" && prefix == r.Prefix
+}
+
+// Like `Sift`, but only for the releases that the ref accepts.
+func (r Ref) sift(rs []Release) ([]Release, error) {
+	kept := make([]Release, 0, len(rs))
+	for _, rel := range rs {
+		if r.Accepts(rel.Tag) {
+			kept = append(kept, rel)
+		}
+	}
+	return Sift(r.ID(), kept, r.Pre)
+}
+
+// Somewhere that the versions of a project can be learned from, like a forge's
+// API, a cache, or a file. Sources can wrap one another, so that, say, a cache
+// only asks Github about what it doesn't already know.
+type VersionSource interface {
+	// The newest release, as in `Recent`.
+	LookupLatest(ctx context.Context, ref Ref) (Release, error)
+	// Every release, newest first, as in `Releases`.
+	LookupAll(ctx context.Context, ref Ref) ([]Release, error)
+}
+
+// A source that can ask whether anything has changed since an earlier lookup,
+// yielding `ErrNotModified` if not. Caches use this to renew stale entries.
+type Revalidator interface {
+	LookupLatestSince(ctx context.Context, ref Ref, prev Validators) (Release, Validators, error)
+	LookupAllSince(ctx context.Context, ref Ref, prev Validators) ([]Release, Validators, error)
+}
+
+// Look up the newest release, conditionally if the source supports that.
+func LatestSince(ctx context.Context, src VersionSource, ref Ref, prev Validators) (Release, Validators, error) {
+	if r, ok := src.(Revalidator); ok {
+		return r.LookupLatestSince(ctx, ref, prev)
+	}
+	rel, err := src.LookupLatest(ctx, ref)
+	return rel, Validators{}, err
+}
+
+// Look up every release, conditionally if the source supports that.
+func AllSince(ctx context.Context, src VersionSource, ref Ref, prev Validators) ([]Release, Validators, error) {
+	if r, ok := src.(Revalidator); ok {
+		return r.LookupAllSince(ctx, ref, prev)
+	}
+	rs, err := src.LookupAll(ctx, ref)
+	return rs, Validators{}, err
+}
+
+// Looks versions up through the REST API of whichever forge hosts a project.
+type RESTSource struct {
+	Forge func(host string) (Forge, error)
+}
+
+func (s RESTSource) LookupLatest(ctx context.Context, ref Ref) (Release, error) {
+	rel, _, err := s.LookupLatestSince(ctx, ref, Validators{})
+	return rel, err
+}
+
+func (s RESTSource) LookupAll(ctx context.Context, ref Ref) ([]Release, error) {
+	rs, _, err := s.LookupAllSince(ctx, ref, Validators{})
+	return rs, err
+}
+
+func (s RESTSource) LookupLatestSince(ctx context.Context, ref Ref, prev Validators) (Release, Validators, error) {
+	forge, e0 := s.Forge(ref.Host)
+	if e0 != nil {
+		return Release{}, Validators{}, e0
+	}
+	rel, vs, e1 := forge.Recent(ctx, ref.Owner, ref.Name, ref.Pre, prev)
+	if e1 != nil || ref.Accepts(rel.Tag) {
+		return rel, vs, e1
+	}
+	// The newest release is of some other kind, so the newest of ours has to
+	// be found among them all. Later lookups then check those directly.
+	rs, avs, e2 := s.LookupAllSince(ctx, ref, Validators{})
+	if e2 != nil {
+		return Release{}, Validators{}, e2
+	}
+	return rs[0], avs, nil
+}
+
+func (s RESTSource) LookupAllSince(ctx context.Context, ref Ref, prev Validators) ([]Release, Validators, error) {
+	forge, e0 := s.Forge(ref.Host)
+	if e0 != nil {
+		return nil, Validators{}, e0
+	}
+	rs, vs, e1 := forge.Releases(ctx, ref.Owner, ref.Name, ref.Pre, prev)
+	if e1 != nil {
+		return nil, vs, e1
+	}
+	sorted, e2 := ref.sift(rs)
+	if e2 != nil {
+		return nil, Validators{}, e2
+	}
+	return sorted, vs, nil
+}
+
+// Looks versions up through Github's GraphQL API, many projects at a time. Only
+// projects that were given to `Prefetch` are answered this way; anything else
+// is passed on to `Fallback`.
+type GraphQLSource struct {
+	Fallback VersionSource
+	// Keyed by `Ref.ID`. Only written before lookups begin.
+	found map[string][]Release
+	errs  map[string]error
+}
+
+func NewGraphQLSource(fallback VersionSource) *GraphQLSource {
+	return &GraphQLSource{
+		Fallback: fallback,
+		found:    make(map[string][]Release),
+		errs:     make(map[string]error),
+	}
+}
+
+// Look up the given projects, which must all live on the host that `client`
+// belongs to. See `BatchReleases`.
+func (s *GraphQLSource) Prefetch(ctx context.Context, client *github.Client, refs []Ref) {
+	repos := make([]string, len(refs))
+	for i, ref := range refs {
+		repos[i] = ref.Owner + "/" + ref.Name
+	}
+	found, errs := BatchReleases(ctx, client, repos)
+	for i, ref := range refs {
+		if rs, ok := found[repos[i]]; ok {
+			s.found[ref.ID()] = rs
+		} else if err, ok := errs[repos[i]]; ok {
+			s.errs[ref.ID()] = err
+		}
+	}
+}
+
+// The prefetched releases of a project, sifted just as `Releases` would. `ok`
+// is false if it wasn't prefetched.
+func (s *GraphQLSource) prefetched(ref Ref) ([]Release, bool, error) {
+	if err, failed := s.errs[ref.ID()]; failed {
+		return nil, true, err
+	}
+	rs, ok := s.found[ref.ID()]
+	if !ok {
+		return nil, false, nil
+	}
+	sorted, err := ref.sift(rs)
+	return sorted, true, err
+}
+
+func (s *GraphQLSource) LookupLatest(ctx context.Context, ref Ref) (Release, error) {
+	rel, _, err := s.LookupLatestSince(ctx, ref, Validators{})
+	return rel, err
+}
+
+func (s *GraphQLSource) LookupAll(ctx context.Context, ref Ref) ([]Release, error) {
+	rs, _, err := s.LookupAllSince(ctx, ref, Validators{})
+	return rs, err
+}
+
+func (s *GraphQLSource) LookupLatestSince(ctx context.Context, ref Ref, prev Validators) (Release, Validators, error) {
+	if rs, ok, err := s.prefetched(ref); ok && err != nil {
+		return Release{}, Validators{}, err
+	} else if ok {
+		return rs[0], Validators{}, nil
+	}
+	return LatestSince(ctx, s.Fallback, ref, prev)
+}
+
+func (s *GraphQLSource) LookupAllSince(ctx context.Context, ref Ref, prev Validators) ([]Release, Validators, error) {
+	if rs, ok, err := s.prefetched(ref); ok {
+		return rs, Validators{}, err
+	}
+	return AllSince(ctx, s.Fallback, ref, prev)
+}
+
+// Versions read from a file instead of being looked up, keyed by `Ref.ID`.
+// Projects missing from it are never looked up elsewhere.
+type StaticSource map[string][]Release
+
+var fullSHA = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// Read a JSON file of the form `{"actions/checkout": [{"tag": "v4.1.1"}]}`.
+// Versions are derived from their tags if not given.
+func ReadStatic(path string) (StaticSource, error) {
+	file, e0 := ioutil.ReadFile(path)
+	if e0 != nil {
+		return nil, e0
+	}
+	s := make(StaticSource)
+	if e1 := json.Unmarshal(file, &s); e1 != nil {
+		return nil, fmt.Errorf("Couldn't parse %s: %v", path, e1)
+	}
+	for id, rs := range s {
+		for i := range rs {
+			if rs[i].Version == "" {
+				rs[i].Version = versionFormat(rs[i].Tag)
+			}
+			if rs[i].Source == "" {
+				rs[i].Source = FromRelease
+			}
+			rs[i].Prerelease = rs[i].Prerelease || isPrerelease(rs[i].Version)
+			// Pins are written as full SHAs, which is all Github accepts.
+			if sha := rs[i].SHA; sha != "" && !fullSHA.MatchString(sha) {
+				return nil, fmt.Errorf("The SHA given for %s@%s in %s isn't a full commit SHA.", id, rs[i].Tag, path)
+			}
+		}
+		s[id] = rs
+	}
+	return s, nil
+}
+
+// Write a file that `ReadStatic` can read back.
+func WriteStatic(path string, s StaticSource) error {
+	bytes, e0 := json.MarshalIndent(s, "", "  ")
+	if e0 != nil {
+		return e0
+	}
+	return ioutil.WriteFile(path, append(bytes, '\n'), 0644)
+}
+
+func (s StaticSource) LookupLatest(ctx context.Context, ref Ref) (Release, error) {
+	rs, err := s.LookupAll(ctx, ref)
+	if err != nil {
+		return Release{}, err
+	}
+	return rs[0], nil
+}
+
+func (s StaticSource) LookupAll(ctx context.Context, ref Ref) ([]Release, error) {
+	rs, found := s[ref.ID()]
+	if !found {
+		return nil, fmt.Errorf("%s isn't listed in the manifest.", ref.ID())
+	}
+	return ref.sift(rs)
+}
@@ -8,4 +8,5 @@ require (
 	github.com/google/go-github/v31 v31.0.0
 	golang.org/x/oauth2 v0.0.0-20180821212333-d2e6202438be
 	gopkg.in/yaml.v2 v2.3.0
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -0,0 +1,100 @@
+package parsing
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A requirement that versions must meet, like `~2`, `<4`, or `>=2.1 <3`. All of
+// its space-separated parts must be satisfied.
+type Constraint struct {
+	raw   string
+	parts []comparator
+}
+
+type comparator struct {
+	op string
+	v  Semver
+}
+
+// Read a constraint as written in a config file. The supported operators are
+// `=`, `<`, `<=`, `>`, `>=`, `~` (same major and minor, or just the same
+// major if no minor is given), and `^` (same major). A bare version means `=`,
+// and like `=` it only compares as far as it was written, so `2` allows
+// `2.1.0`.
+func ParseConstraint(s string) (Constraint, error) {
+	c := Constraint{raw: strings.TrimSpace(s)}
+	for _, field := range strings.Fields(s) {
+		i := strings.IndexFunc(field, func(r rune) bool { return !strings.ContainsRune("<>=~^", r) })
+		if i < 0 {
+			return Constraint{}, fmt.Errorf("Malformed version constraint: %s", s)
+		}
+		op := field[:i]
+		switch op {
+		case "", "=", "<", "<=", ">", ">=", "~", "^":
+		default:
+			return Constraint{}, fmt.Errorf("Unknown operator %q in version constraint: %s", op, s)
+		}
+		v, err := ParseSemver(field[len(op):])
+		if err != nil {
+			return Constraint{}, fmt.Errorf("Malformed version constraint: %s", s)
+		}
+		if op == "" {
+			op = "="
+		}
+		c.parts = append(c.parts, comparator{op, v})
+	}
+	return c, nil
+}
+
+// Does this constraint place no restrictions at all?
+func (c Constraint) Empty() bool {
+	return len(c.parts) == 0
+}
+
+func (c Constraint) String() string {
+	return c.raw
+}
+
+// Does the given version satisfy every part of the constraint? Unreadable
+// versions never do, unless the constraint is empty.
+func (c Constraint) Allows(version string) bool {
+	if c.Empty() {
+		return true
+	}
+	v, err := ParseSemver(version)
+	if err != nil {
+		return false
+	}
+	for _, p := range c.parts {
+		if !p.allows(v) {
+			return false
+		}
+	}
+	return true
+}
+
+func (p comparator) allows(v Semver) bool {
+	switch p.op {
+	case "=":
+		return v.truncate(p.v.Granularity).Compare(p.v) == 0
+	case "<":
+		return v.Compare(p.v) < 0
+	case "<=":
+		return v.Compare(p.v) <= 0
+	case ">":
+		return v.Compare(p.v) > 0
+	case ">=":
+		return v.Compare(p.v) >= 0
+	case "~":
+		upper := Semver{Major: p.v.Major + 1}
+		if p.v.Granularity > Major {
+			upper = Semver{Major: p.v.Major, Minor: p.v.Minor + 1}
+		}
+		return v.Compare(p.v) >= 0 && v.Compare(upper) < 0
+	case "^":
+		return v.Compare(p.v) >= 0 && v.Major == p.v.Major
+	default:
+		return false
+	}
+}
@@ -0,0 +1,43 @@
+package parsing
+
+import "testing"
+
+func TestConstraintAllows(t *testing.T) {
+	cases := []struct {
+		constraint string
+		version    string
+		expected   bool
+	}{
+		{"", "9.9.9", true},
+		{"~2", "2.9.1", true},
+		{"~2", "3.0.0", false},
+		{"~2.1", "2.1.7", true},
+		{"~2.1", "2.2.0", false},
+		{"<4", "3.9.9", true},
+		{"<4", "4.0.0", false},
+		{">=2.1 <3", "2.0.9", false},
+		{">=2.1 <3", "2.5.0", true},
+		{"^2.1", "2.9.0", true},
+		{"^2.1", "3.0.0", false},
+		{"2", "2.1.0", true},
+		{"=2.1.0", "2.1.1", false},
+		{"2.1.0", "2.1.0", true},
+		{"<4", "main", false},
+	}
+	for _, c := range cases {
+		cons, err := ParseConstraint(c.constraint)
+		if err != nil {
+			t.Errorf("ParseConstraint(%s): unexpected error %s", c.constraint, err)
+		} else if actual := cons.Allows(c.version); actual != c.expected {
+			t.Errorf("Allows(%s, %s): expected %t", c.constraint, c.version, c.expected)
+		}
+	}
+}
+
+func TestParseConstraintErrors(t *testing.T) {
+	for _, bad := range []string{"!2", "<four", "<<2", "~"} {
+		if _, err := ParseConstraint(bad); err == nil {
+			t.Errorf("ParseConstraint(%s): expected an error", bad)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package parsing
+
+import (
+	"fmt"
+	"strings"
+)
+
+// How many components of a version are written out, like the two of `v2.1`.
+type Granularity int
+
+const (
+	Major Granularity = 1 // Like `v2`.
+	Minor Granularity = 2 // Like `v2.1`.
+	Patch Granularity = 3 // Like `v2.1.0`.
+)
+
+// Read a granularity as written in a config file. The empty string yields 0,
+// meaning that the granularity of each existing reference should be kept.
+func ParseGranularity(s string) (Granularity, error) {
+	switch s {
+	case "":
+		return 0, nil
+	case "major":
+		return Major, nil
+	case "minor":
+		return Minor, nil
+	case "patch", "full":
+		return Patch, nil
+	default:
+		return 0, fmt.Errorf("Unknown version granularity: %s", s)
+	}
+}
+
+// The granularity of a version string like `2.1.0`, ignoring any prerelease or
+// build metadata.
+func GranularityOf(version string) Granularity {
+	core := numericCore(version)
+	parts := len(strings.Split(core, "."))
+	if parts > int(Patch) {
+		return Patch
+	}
+	return Granularity(parts)
+}
+
+// Shorten a version to the given granularity, such that `2.1.0` becomes `2` for
+// `Major`. Versions that are already at or coarser than the granularity are
+// returned as-is.
+func (g Granularity) Truncate(version string) string {
+	if g <= 0 || GranularityOf(version) <= g {
+		return version
+	}
+	parts := strings.Split(numericCore(version), ".")
+	return strings.Join(parts[:g], ".")
+}
+
+// Drop the prerelease and build metadata from a version.
+func numericCore(version string) string {
+	if i := strings.IndexAny(version, "-+"); i >= 0 {
+		return version[:i]
+	}
+	return version
+}
@@ -0,0 +1,41 @@
+package parsing
+
+import "testing"
+
+func TestGranularityOf(t *testing.T) {
+	cases := map[string]Granularity{"2": Major, "2.1": Minor, "2.1.0": Patch, "2.1.0-rc.1": Patch}
+	for version, g := range cases {
+		if actual := GranularityOf(version); actual != g {
+			t.Errorf("GranularityOf(%s): expected %d, got %d", version, g, actual)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	cases := []struct {
+		g        Granularity
+		version  string
+		expected string
+	}{
+		{Major, "4.1.1", "4"},
+		{Minor, "4.1.1", "4.1"},
+		{Patch, "4.1.1", "4.1.1"},
+		{Patch, "4.1", "4.1"},
+		{Major, "4", "4"},
+		{0, "4.1.1", "4.1.1"},
+	}
+	for _, c := range cases {
+		if actual := c.g.Truncate(c.version); actual != c.expected {
+			t.Errorf("Truncate(%d, %s): expected %s, got %s", c.g, c.version, c.expected, actual)
+		}
+	}
+}
+
+func TestParseGranularity(t *testing.T) {
+	if g, err := ParseGranularity("minor"); err != nil || g != Minor {
+		t.Errorf("ParseGranularity(minor): got %d (%v)", g, err)
+	}
+	if _, err := ParseGranularity("huge"); err == nil {
+		t.Errorf("ParseGranularity(huge): expected an error")
+	}
+}
@@ -0,0 +1,66 @@
+package parsing
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// The registry that images without one are pulled from.
+const DockerHub = "docker.io"
+
+// The numeric part of an image tag, and what surrounds it, like the `3.12` of
+// `3.12-alpine`.
+var imageTagRx = regexp.MustCompile(`^([A-Za-z_.-]*?)(\d+(?:\.\d+){0,2})(.*)$`)
+
+// Form an `Action` from a container image, as given to `container:`,
+// `services:`, or a `docker://` step, like:
+//
+//	node:16
+//	ghcr.io/owner/app:1.2-slim
+//
+// Images pinned to a digest, or without a numbered tag, have no `Version` and
+// are never updated.
+func ParseImage(value string) (Action, error) {
+	name := value
+	tag := ""
+	if at := strings.Index(name, "@"); at >= 0 {
+		name = name[:at] // Pinned to a digest.
+	} else if colon := strings.LastIndex(name, ":"); colon > strings.LastIndex(name, "/") {
+		name, tag = name[:colon], name[colon+1:]
+	}
+	if name == "" || strings.HasPrefix(name, "/") || strings.HasSuffix(name, "/") {
+		return Action{}, fmt.Errorf("Expected an image in %q.", value)
+	}
+
+	registry, path := DockerHub, name
+	parts := strings.SplitN(name, "/", 2)
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		registry, path = parts[0], parts[1]
+	}
+	// Docker Hub's official images live under `library/`.
+	if registry == DockerHub && !strings.Contains(path, "/") {
+		path = "library/" + path
+	}
+	action := Action{Registry: registry, Image: name, Name: path, Ref: value, Kind: DockerImage}
+	// Other registries may well keep images at their top level, like
+	// `localhost:5000/app`.
+	if slash := strings.LastIndex(path, "/"); slash >= 0 {
+		action.Owner, action.Name = path[:slash], path[slash+1:]
+	}
+	if tag != "" && !strings.Contains(value, "@") {
+		action.Prefix, action.Version, action.Suffix = SplitImageTag(tag)
+	}
+	return action, nil
+}
+
+// Split an image tag like `v3.12-alpine` into its prefix, version, and suffix,
+// yielding `v`, `3.12`, and `-alpine`. Tags without a version, like `latest`,
+// yield empty strings.
+func SplitImageTag(tag string) (string, string, string) {
+	m := imageTagRx.FindStringSubmatch(tag)
+	if m == nil {
+		return "", "", ""
+	}
+	return m[1], m[2], m[3]
+}
@@ -1,45 +1,303 @@
 package parsing
 
 import (
+	"fmt"
+	"path"
+	"regexp"
 	"strings"
 )
 
+// The sort of thing a `uses` value refers to. For Actions that live in a Github
+// repository, this is determined by the reference that follows the `@`.
+type Kind int
+
+const (
+	SemverTag   Kind = iota // A full or partial version tag, like `v1.2.3`, `1.2`, or `release-1.2.3`.
+	MajorTag                // A floating major version tag, like `v1`.
+	Branch                  // Anything else following the `@`, like `main`.
+	CommitSHA               // A full 40-character commit hash.
+	LocalPath               // An Action within the same repository, like `./my-action`.
+	DockerImage             // An image to run directly, like `docker://alpine:3`.
+)
+
+func (k Kind) String() string {
+	switch k {
+	case SemverTag:
+		return "semver tag"
+	case MajorTag:
+		return "major tag"
+	case Branch:
+		return "branch"
+	case CommitSHA:
+		return "commit SHA"
+	case LocalPath:
+		return "local path"
+	case DockerImage:
+		return "docker image"
+	default:
+		return "unknown"
+	}
+}
+
 type Action struct {
-	Owner   string
-	Name    string
+	// Whatever precedes the `Owner` for Actions given by full URL, like
+	// `https://code.forgejo.org/`. Otherwise, the host is up to the runner.
+	URL    string
+	Owner  string
+	Name   string
+	Path   string // A subdirectory of the repository, like the `init` of `github/codeql-action/init`.
+	Ref    string // Everything after the `@`, or the entire value for local paths and Docker images.
+	Prefix string // Whatever precedes the `Version` in the tag, like `v` or `release-`.
+	// The `Ref` without its `Prefix` for tag references, or the version noted
+	// in the trailing comment of a pinned commit, like:
+	//
+	//	actions/checkout@a81bbbf8298c0fa03ea29cdc473d45769f953675 # v4.1.1
 	Version string
+	Kind    Kind
+	// For images, the registry they're pulled from, like `ghcr.io`, and their
+	// name as written, like `node`. Their `Owner` and `Name` are those of their
+	// full path within the registry, like `library/node`.
+	Registry string
+	Image    string
+	// Whatever follows the `Version` in an image tag, like `-alpine`.
+	Suffix string
+	// A job-level call to a reusable workflow, like
+	// `org/repo/.github/workflows/build.yml@v1`, rather than a step's Action.
+	Reusable bool
+	Line     int // Position of the `uses` value within its workflow file.
+	Column   int
+	// Shares its line with another step, like the flow-style
+	// `steps: [{uses: a/b@v1}, {uses: c/d@v1}]`, so a trailing comment can't
+	// be told apart as its own.
+	Crowded bool
 }
 
-// The full `owner/repo@vN` format.
+var majorRx = regexp.MustCompile(`^\d+$`)
+var shaRx = regexp.MustCompile(`^[0-9a-f]{40}$`)
+var prefixRx = regexp.MustCompile(`^[A-Za-z_./-]*$`)
+
+// The full `owner/repo@ref` format, exactly as it was written.
 func (a Action) Raw() string {
-	return a.Repo() + "@v" + a.Version
+	switch a.Kind {
+	case LocalPath, DockerImage:
+		return a.Ref
+	default:
+		return a.Location() + "@" + a.Ref
+	}
 }
 
-// The `owner/repo` format.
+// The `owner/repo` format. Versions are always looked up against this, even
+// for Actions that live in a subdirectory. Images are given by name instead.
 func (a Action) Repo() string {
+	if a.Kind == DockerImage {
+		return a.Image
+	}
 	return a.Owner + "/" + a.Name
 }
 
-// Given the contents of a workflow YAML file, find all uses of a Github Action.
-func Actions(file string) []Action {
-	lines := strings.Split(file, "\n")
-	actions := make([]Action, 0, len(lines))
-	for _, line := range lines {
-		l := strings.TrimSpace(line)
-		if strings.HasPrefix(l, "uses:") {
-			action := parseAction(l)
-			actions = append(actions, action)
+// The `owner/repo/path` format, with the path only present if there was one,
+// and the `URL` if there was one.
+func (a Action) Location() string {
+	if a.Kind == DockerImage {
+		return a.URL + a.Image
+	}
+	if a.Path == "" {
+		return a.URL + a.Repo()
+	}
+	return a.URL + a.Repo() + "/" + a.Path
+}
+
+// The host of an Action given by full URL, like `code.forgejo.org`, or the
+// empty string otherwise.
+func (a Action) Host() string {
+	i := strings.Index(a.URL, "://")
+	if i < 0 {
+		return ""
+	}
+	return strings.TrimSuffix(a.URL[i+3:], "/")
+}
+
+// The reference as it would be written with the given version instead.
+func (a Action) WithVersion(version string) string {
+	if a.Kind == DockerImage {
+		return a.Location() + ":" + a.Prefix + version + a.Suffix
+	}
+	return a.Location() + "@" + a.Prefix + version
+}
+
+// Does this Action refer to a version tag?
+func (a Action) Tagged() bool {
+	return a.Kind == SemverTag || a.Kind == MajorTag
+}
+
+// Is this Action pinned to a specific commit?
+func (a Action) Pinned() bool {
+	return a.Kind == CommitSHA
+}
+
+// Given a parsed workflow file, find all uses of a Github Action, as well as
+// the images of its containers and services. Values that couldn't be
+// understood are reported separately, so that one odd step doesn't prevent
+// the rest from being checked.
+func Actions(w *Workflow) ([]Action, []error) {
+	uses := w.Uses()
+	images := w.Images()
+	actions := make([]Action, 0, len(uses)+len(images))
+	errs := make([]error, 0)
+	perLine := make(map[int]int)
+	for _, u := range uses {
+		perLine[u.Line]++
+	}
+	for i, u := range append(uses, images...) {
+		parse := ParseAction
+		if i >= len(uses) {
+			// Images chosen by expression, like `${{ matrix.image }}`, can't
+			// be known ahead of time.
+			if strings.Contains(u.Value, "${{") {
+				continue
+			}
+			parse = ParseImage
+		} else if u.Job {
+			parse = ParseReusable
+		}
+		action, err := parse(u.Value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Line %d: %s", u.Line, err))
+			continue
+		}
+		if action.Kind == CommitSHA {
+			action.Prefix, action.Version = SplitPrefix(u.Comment)
+		}
+		action.Line = u.Line
+		action.Column = u.Column
+		action.Crowded = i < len(uses) && perLine[u.Line] > 1
+		actions = append(actions, action)
+	}
+	return actions, errs
+}
+
+// Form an `Action`, given a `uses` value like:
+//
+//	actions/checkout@v2
+//	https://code.forgejo.org/actions/checkout@v4
+func ParseAction(value string) (Action, error) {
+	if strings.HasPrefix(value, "./") || strings.HasPrefix(value, "../") {
+		return Action{Ref: value, Kind: LocalPath}, nil
+	}
+	if strings.HasPrefix(value, "docker://") {
+		if len(value) == len("docker://") {
+			return Action{}, fmt.Errorf("No image given in %q.", value)
+		}
+		action, err := ParseImage(strings.TrimPrefix(value, "docker://"))
+		if err != nil {
+			return Action{}, err
+		}
+		action.URL = "docker://"
+		action.Ref = value
+		return action, nil
+	}
+
+	at := strings.Index(value, "@")
+	if at < 0 {
+		return Action{}, fmt.Errorf("No version given in %q.", value)
+	}
+	repo, ref := value[:at], value[at+1:]
+	base := ""
+	if i := strings.Index(repo, "://"); i >= 0 {
+		slash := strings.Index(repo[i+3:], "/")
+		if slash <= 0 {
+			return Action{}, fmt.Errorf("Expected an `owner/repo` in %q.", value)
 		}
+		base, repo = repo[:i+3+slash+1], repo[i+3+slash+1:]
+	}
+	parts := strings.SplitN(repo, "/", 3)
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return Action{}, fmt.Errorf("Expected an `owner/repo` in %q.", value)
+	}
+	sub := ""
+	if len(parts) == 3 {
+		sub = parts[2]
+		if sub == "" {
+			return Action{}, fmt.Errorf("Empty subdirectory in %q.", value)
+		}
+	}
+	if ref == "" {
+		return Action{}, fmt.Errorf("Empty version in %q.", value)
+	}
+
+	action := Action{URL: base, Owner: parts[0], Name: parts[1], Path: sub, Ref: ref, Kind: refKind(ref)}
+	if action.Tagged() {
+		action.Prefix, action.Version = SplitPrefix(ref)
+	}
+	return action, nil
+}
+
+// Split a tag like `release-1.0` into its prefix and version, yielding
+// `release-` and `1.0`. The prefix is everything before the first digit, and
+// may well be empty. Tags that don't end in a semantic version have no
+// version, and yield empty strings.
+func SplitPrefix(tag string) (string, string) {
+	i := strings.IndexAny(tag, "0123456789")
+	if i < 0 || !prefixRx.MatchString(tag[:i]) {
+		return "", ""
+	}
+	if _, err := ParseSemver(tag[i:]); err != nil {
+		return "", ""
 	}
-	return actions
+	return tag[:i], tag[i:]
 }
 
-// Form an `Action`, given a line like:
+// Form an `Action` from a job-level `uses` value, which must refer to a
+// workflow file, like:
 //
-//      uses: actions/checkout@v2
-func parseAction(line string) Action {
-	dropped := line[6:]
-	owner := strings.SplitN(dropped, "/", 2)
-	name := strings.SplitN(owner[1], "@", 2)
-	return Action{Owner: owner[0], Name: name[0], Version: name[1][1:]}
+//	org/repo/.github/workflows/build.yml@v1
+func ParseReusable(value string) (Action, error) {
+	action, err := ParseAction(value)
+	if err != nil {
+		return Action{}, err
+	}
+	file := action.Path
+	if action.Kind == LocalPath {
+		file = strings.TrimPrefix(action.Ref, "./")
+	}
+	if action.Kind == DockerImage || !isWorkflowFile(file) {
+		return Action{}, fmt.Errorf("Expected a reusable workflow in %q.", value)
+	}
+	action.Reusable = true
+	return action, nil
+}
+
+// The directories that workflow files live in, depending on the forge.
+var WorkflowDirs = []string{".github/workflows", ".gitea/workflows", ".forgejo/workflows"}
+
+// Reusable workflows must live directly within a workflow directory.
+func isWorkflowFile(file string) bool {
+	dir, name := path.Split(file)
+	ext := path.Ext(name)
+	if ext != ".yml" && ext != ".yaml" {
+		return false
+	}
+	for _, d := range WorkflowDirs {
+		if dir == d+"/" {
+			return true
+		}
+	}
+	return false
+}
+
+// Classify the reference that follows the `@` of a `uses` value.
+func refKind(ref string) Kind {
+	switch {
+	case shaRx.MatchString(ref):
+		return CommitSHA
+	}
+	_, version := SplitPrefix(ref)
+	switch {
+	case version == "":
+		return Branch
+	case majorRx.MatchString(version):
+		return MajorTag
+	default:
+		return SemverTag
+	}
 }
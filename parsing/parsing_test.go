@@ -3,15 +3,81 @@ package parsing
 import "testing"
 
 func TestParseAction(t *testing.T) {
-	action := parseAction("uses: actions/checkout@v2")
-	expected := Action{"actions", "checkout", "2"}
-	if action != expected {
-		t.Errorf("parseAction: expected %s, got %s", expected, action)
+	action, err := ParseAction("actions/checkout@v2")
+	expected := Action{Owner: "actions", Name: "checkout", Ref: "v2", Prefix: "v", Version: "2", Kind: MajorTag}
+	if err != nil || action != expected {
+		t.Errorf("ParseAction: expected %v, got %v (%v)", expected, action, err)
+	}
+}
+
+func TestParseActionKinds(t *testing.T) {
+	cases := map[string]Kind{
+		"actions/checkout@v2":                                       MajorTag,
+		"actions/checkout@v2.1":                                     SemverTag,
+		"actions/checkout@v2.1.0":                                   SemverTag,
+		"actions/checkout@1.2.3":                                    SemverTag,
+		"actions/checkout@v2-rc1":                                   SemverTag,
+		"actions/checkout@release-1.0":                              SemverTag,
+		"actions/checkout@2":                                        MajorTag,
+		"actions/checkout@v":                                        Branch,
+		"actions/checkout@main":                                     Branch,
+		"github/codeql-action/init@v2":                              MajorTag,
+		"actions/checkout@a81bbbf8298c0fa03ea29cdc473d45769f953675": CommitSHA,
+		"./.github/actions/build":                                   LocalPath,
+		"docker://alpine:3":                                         DockerImage,
+	}
+	for value, kind := range cases {
+		action, err := ParseAction(value)
+		if err != nil {
+			t.Errorf("ParseAction(%s): unexpected error %s", value, err)
+		} else if action.Kind != kind {
+			t.Errorf("ParseAction(%s): expected %s, got %s", value, kind, action.Kind)
+		} else if action.Raw() != value {
+			t.Errorf("ParseAction(%s): Raw yielded %s", value, action.Raw())
+		}
+	}
+}
+
+func TestParseActionPath(t *testing.T) {
+	action, err := ParseAction("aws-actions/amazon-ecr-login/sub/dir@v1")
+	expected := Action{Owner: "aws-actions", Name: "amazon-ecr-login", Path: "sub/dir", Ref: "v1", Prefix: "v", Version: "1", Kind: MajorTag}
+	if err != nil || action != expected {
+		t.Errorf("ParseAction: expected %v, got %v (%v)", expected, action, err)
+	}
+	if repo := action.Repo(); repo != "aws-actions/amazon-ecr-login" {
+		t.Errorf("Repo: expected the owning repository, got %s", repo)
+	}
+	if raw := action.Raw(); raw != "aws-actions/amazon-ecr-login/sub/dir@v1" {
+		t.Errorf("Raw: expected the path to be preserved, got %s", raw)
+	}
+}
+
+func TestParseActionURL(t *testing.T) {
+	value := "https://code.forgejo.org/actions/checkout@v4"
+	action, err := ParseAction(value)
+	expected := Action{URL: "https://code.forgejo.org/", Owner: "actions", Name: "checkout", Ref: "v4", Prefix: "v", Version: "4", Kind: MajorTag}
+	if err != nil || action != expected {
+		t.Errorf("ParseAction: expected %v, got %v (%v)", expected, action, err)
+	}
+	if host := action.Host(); host != "code.forgejo.org" {
+		t.Errorf("Host: expected code.forgejo.org, got %s", host)
+	}
+	if raw := action.Raw(); raw != value {
+		t.Errorf("Raw: expected the URL to be preserved, got %s", raw)
+	}
+}
+
+func TestParseActionErrors(t *testing.T) {
+	bad := []string{"", "actions/checkout", "checkout@v2", "/checkout@v2", "actions/@v2", "actions/checkout@", "actions/checkout/@v2", "docker://", "https://host@v1"}
+	for _, value := range bad {
+		if _, err := ParseAction(value); err == nil {
+			t.Errorf("ParseAction(%q): expected an error", value)
+		}
 	}
 }
 
 func TestRaw(t *testing.T) {
-	raw := Action{"actions", "checkout", "2"}.Raw()
+	raw := Action{Owner: "actions", Name: "checkout", Ref: "v2", Prefix: "v", Version: "2"}.Raw()
 	expected := "actions/checkout@v2"
 	if raw != expected {
 		t.Errorf("Raw: expected %s, got %s", expected, raw)
@@ -19,13 +85,126 @@ func TestRaw(t *testing.T) {
 }
 
 func TestActions(t *testing.T) {
-	actions := Actions("uses: actions/checkout@v2\n   uses: actions/cache@v1")
+	w, _ := ParseWorkflow("jobs:\n  build:\n    steps:\n      - uses: actions/checkout@v2\n      - uses: checkout\n      - uses: actions/cache@v1.1")
+	actions, errs := Actions(w)
 	expected := []Action{
-		{"actions", "checkout", "2"},
-		{"actions", "cache", "1"}}
+		{Owner: "actions", Name: "checkout", Ref: "v2", Prefix: "v", Version: "2", Kind: MajorTag, Line: 4, Column: 15},
+		{Owner: "actions", Name: "cache", Ref: "v1.1", Prefix: "v", Version: "1.1", Kind: SemverTag, Line: 6, Column: 15}}
+	if len(actions) != len(expected) {
+		t.Fatalf("Actions: expected %d actions, got %d", len(expected), len(actions))
+	}
 	for i, v := range actions {
 		if v != expected[i] {
-			t.Errorf("Raw: expected %s, got %s", expected[i], v)
+			t.Errorf("Actions: expected %v, got %v", expected[i], v)
+		}
+	}
+	if len(errs) != 1 {
+		t.Errorf("Actions: expected one error, got %v", errs)
+	}
+}
+
+func TestParseReusable(t *testing.T) {
+	action, err := ParseReusable("org/repo/.github/workflows/build.yml@v1.2")
+	expected := Action{Owner: "org", Name: "repo", Path: ".github/workflows/build.yml", Ref: "v1.2", Prefix: "v", Version: "1.2", Kind: SemverTag, Reusable: true}
+	if err != nil || action != expected {
+		t.Errorf("ParseReusable: expected %v, got %v (%v)", expected, action, err)
+	}
+	for _, local := range []string{"./.github/workflows/build.yaml", "./.forgejo/workflows/build.yml"} {
+		if _, err := ParseReusable(local); err != nil {
+			t.Errorf("ParseReusable: unexpected error for a local workflow: %s", err)
 		}
 	}
+	for _, bad := range []string{"actions/checkout@v2", "org/repo/build.yml@v1", "docker://alpine:3"} {
+		if _, err := ParseReusable(bad); err == nil {
+			t.Errorf("ParseReusable(%s): expected an error", bad)
+		}
+	}
+}
+
+func TestActionsPinned(t *testing.T) {
+	sha := "a81bbbf8298c0fa03ea29cdc473d45769f953675"
+	w, _ := ParseWorkflow("jobs:\n  a:\n    steps:\n    - uses: actions/checkout@" + sha + " # v4.1.1\n    - uses: actions/cache@" + sha + " # Trust me.\n")
+	actions, _ := Actions(w)
+	if len(actions) != 2 {
+		t.Fatalf("Actions: expected 2 actions, got %v", actions)
+	}
+	if !actions[0].Pinned() || actions[0].Version != "4.1.1" {
+		t.Errorf("Actions: expected the version to be read from the comment, got %v", actions[0])
+	}
+	if actions[1].Version != "" {
+		t.Errorf("Actions: expected no version from an unrelated comment, got %v", actions[1])
+	}
+}
+
+func TestSplitPrefix(t *testing.T) {
+	cases := []struct {
+		tag     string
+		prefix  string
+		version string
+	}{
+		{"v1.2.3", "v", "1.2.3"},
+		{"1.2.3", "", "1.2.3"},
+		{"release-1.0", "release-", "1.0"},
+		{"v2.0.0-rc.1", "v", "2.0.0-rc.1"},
+		{"main", "", ""},
+		{"", "", ""},
+		{"node 16", "", ""},
+	}
+	for _, c := range cases {
+		prefix, version := SplitPrefix(c.tag)
+		if prefix != c.prefix || version != c.version {
+			t.Errorf("SplitPrefix(%s): expected (%s, %s), got (%s, %s)", c.tag, c.prefix, c.version, prefix, version)
+		}
+	}
+}
+
+func TestParseImage(t *testing.T) {
+	cases := map[string]Action{
+		"node:16-alpine":                {Registry: "docker.io", Image: "node", Owner: "library", Name: "node", Ref: "node:16-alpine", Version: "16", Suffix: "-alpine", Kind: DockerImage},
+		"ghcr.io/org/app:v1.2":          {Registry: "ghcr.io", Image: "ghcr.io/org/app", Owner: "org", Name: "app", Ref: "ghcr.io/org/app:v1.2", Prefix: "v", Version: "1.2", Kind: DockerImage},
+		"localhost:5000/a/b/c":          {Registry: "localhost:5000", Image: "localhost:5000/a/b/c", Owner: "a/b", Name: "c", Ref: "localhost:5000/a/b/c", Kind: DockerImage},
+		"localhost:5000/app:1.2":        {Registry: "localhost:5000", Image: "localhost:5000/app", Name: "app", Ref: "localhost:5000/app:1.2", Version: "1.2", Kind: DockerImage},
+		"myregistry.azurecr.io/app:1.0": {Registry: "myregistry.azurecr.io", Image: "myregistry.azurecr.io/app", Name: "app", Ref: "myregistry.azurecr.io/app:1.0", Version: "1.0", Kind: DockerImage},
+		"redis:latest":                  {Registry: "docker.io", Image: "redis", Owner: "library", Name: "redis", Ref: "redis:latest", Kind: DockerImage},
+		"alpine@sha256:abc":             {Registry: "docker.io", Image: "alpine", Owner: "library", Name: "alpine", Ref: "alpine@sha256:abc", Kind: DockerImage},
+	}
+	for value, expected := range cases {
+		if action, err := ParseImage(value); err != nil || action != expected {
+			t.Errorf("ParseImage(%s): expected %v, got %v (%v)", value, expected, action, err)
+		}
+	}
+	action, _ := ParseAction("docker://alpine:3.12")
+	if action.Raw() != "docker://alpine:3.12" || action.WithVersion("3.19") != "docker://alpine:3.19" {
+		t.Errorf("ParseAction: expected docker:// to be kept, got %s and %s", action.Raw(), action.WithVersion("3.19"))
+	}
+	if _, err := ParseImage("/app:1"); err == nil {
+		t.Errorf("ParseImage: expected an error for a malformed image")
+	}
+}
+
+func TestActionsImages(t *testing.T) {
+	w, _ := ParseWorkflow(`jobs:
+  build:
+    container: node:16
+    services:
+      db:
+        image: "postgres:13.2"
+      cache:
+        image: ${{ matrix.redis }}
+    steps:
+      - uses: docker://alpine:3
+`)
+	actions, errs := Actions(w)
+	if len(errs) != 0 || len(actions) != 3 {
+		t.Fatalf("Actions: expected three images, got %v (%v)", actions, errs)
+	}
+	if a := actions[0]; a.Location() != "docker://alpine" || a.Version != "3" {
+		t.Errorf("Actions: expected the step's image first, got %v", a)
+	}
+	if a := actions[1]; a.Repo() != "node" || a.Line != 3 || a.Column != 16 {
+		t.Errorf("Actions: expected the container's image, got %v", a)
+	}
+	if a := actions[2]; a.Repo() != "postgres" || a.Version != "13.2" || a.Column != 17 {
+		t.Errorf("Actions: expected the service's image, got %v", a)
+	}
 }
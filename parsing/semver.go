@@ -0,0 +1,131 @@
+package parsing
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A semantic version, as far as it was written. Missing minor or patch
+// components count as 0, but the `Granularity` records how many were present.
+type Semver struct {
+	Major       int
+	Minor       int
+	Patch       int
+	Pre         string // Prerelease identifiers, like the `rc.1` of `2.0.0-rc.1`.
+	Build       string // Build metadata, which doesn't affect ordering.
+	Granularity Granularity
+}
+
+var semverFullRx = regexp.MustCompile(`^v?(\d+)(?:\.(\d+))?(?:\.(\d+))?(?:-([0-9A-Za-z.-]+))?(?:\+([0-9A-Za-z.-]+))?$`)
+
+// Read a version like `v2`, `2.1`, or `2.1.0-rc.1+build.5`.
+func ParseSemver(s string) (Semver, error) {
+	m := semverFullRx.FindStringSubmatch(s)
+	if m == nil {
+		return Semver{}, fmt.Errorf("Not a semantic version: %s", s)
+	}
+	v := Semver{Pre: m[4], Build: m[5], Granularity: Major}
+	v.Major, _ = strconv.Atoi(m[1])
+	if m[2] != "" {
+		v.Minor, _ = strconv.Atoi(m[2])
+		v.Granularity = Minor
+	}
+	if m[3] != "" {
+		v.Patch, _ = strconv.Atoi(m[3])
+		v.Granularity = Patch
+	}
+	return v, nil
+}
+
+// Yields -1, 0, or 1 if `a` is older than, equal to, or newer than `b`. Build
+// metadata is ignored, and a prerelease is older than its associated release.
+func (a Semver) Compare(b Semver) int {
+	if c := compareInt(a.Major, b.Major); c != 0 {
+		return c
+	}
+	if c := compareInt(a.Minor, b.Minor); c != 0 {
+		return c
+	}
+	if c := compareInt(a.Patch, b.Patch); c != 0 {
+		return c
+	}
+	return comparePre(a.Pre, b.Pre)
+}
+
+// Is the `candidate` version strictly newer than the `current` one? The two
+// are compared only as far as the coarser of them was written, so that `2` is
+// not considered older than `2.1.0`, nor `2.1.0` newer than `2`. Versions that
+// can't be read are never newer.
+func Newer(candidate, current string) bool {
+	a, e0 := ParseSemver(candidate)
+	b, e1 := ParseSemver(current)
+	if e0 != nil || e1 != nil {
+		return false
+	}
+	gran := a.Granularity
+	if b.Granularity < gran {
+		gran = b.Granularity
+	}
+	return a.truncate(gran).Compare(b.truncate(gran)) > 0
+}
+
+// Zero out the components beyond the given granularity. Prereleases only have
+// meaning for full versions, and are otherwise dropped.
+func (a Semver) truncate(g Granularity) Semver {
+	if g < Patch {
+		a.Patch = 0
+		a.Pre = ""
+	}
+	if g < Minor {
+		a.Minor = 0
+	}
+	return a
+}
+
+func compareInt(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// Compare prerelease identifiers according to the semver spec: numeric
+// identifiers are compared numerically and sort before alphanumeric ones, and a
+// shorter set of identifiers sorts first if all else is equal.
+func comparePre(a, b string) int {
+	switch {
+	case a == b:
+		return 0
+	case a == "":
+		return 1
+	case b == "":
+		return -1
+	}
+	as := strings.Split(a, ".")
+	bs := strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		an, e0 := strconv.Atoi(as[i])
+		bn, e1 := strconv.Atoi(bs[i])
+		var c int
+		switch {
+		case e0 == nil && e1 == nil:
+			c = compareInt(an, bn)
+		case e0 == nil:
+			c = -1
+		case e1 == nil:
+			c = 1
+		default:
+			c = strings.Compare(as[i], bs[i])
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return compareInt(len(as), len(bs))
+}
@@ -0,0 +1,61 @@
+package parsing
+
+import "testing"
+
+func TestParseSemver(t *testing.T) {
+	v, err := ParseSemver("v2.1.0-rc.1+build.5")
+	expected := Semver{2, 1, 0, "rc.1", "build.5", Patch}
+	if err != nil || v != expected {
+		t.Errorf("ParseSemver: expected %v, got %v (%v)", expected, v, err)
+	}
+	v, err = ParseSemver("3")
+	expected = Semver{Major: 3, Granularity: Major}
+	if err != nil || v != expected {
+		t.Errorf("ParseSemver: expected %v, got %v (%v)", expected, v, err)
+	}
+	for _, bad := range []string{"", "v", "main", "1.2.3.4", "1.x"} {
+		if _, err := ParseSemver(bad); err == nil {
+			t.Errorf("ParseSemver(%s): expected an error", bad)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	// Each is strictly older than the next.
+	ordered := []string{"1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta", "1.0.0-beta.2", "1.0.0-beta.11", "1.0.0-rc.1", "1.0.0", "1.0.1", "1.1", "2"}
+	for i := 0; i+1 < len(ordered); i++ {
+		a, _ := ParseSemver(ordered[i])
+		b, _ := ParseSemver(ordered[i+1])
+		if a.Compare(b) != -1 || b.Compare(a) != 1 {
+			t.Errorf("Compare: expected %s < %s", ordered[i], ordered[i+1])
+		}
+	}
+	a, _ := ParseSemver("1.0.0+one")
+	b, _ := ParseSemver("v1.0.0+two")
+	if a.Compare(b) != 0 {
+		t.Errorf("Compare: build metadata should be ignored")
+	}
+}
+
+func TestNewer(t *testing.T) {
+	cases := []struct {
+		candidate string
+		current   string
+		expected  bool
+	}{
+		{"4", "2", true},
+		{"2.1.0", "2", false},
+		{"2", "2.1.0", false},
+		{"2.0.9", "2.1.0", false},
+		{"2.1.1", "2.1.0", true},
+		{"3.0.0-beta.1", "2", true},
+		{"2.1.0-beta.1", "2.1.0", false},
+		{"2.1.0", "2.1.0-beta.1", true},
+		{"main", "2", false},
+	}
+	for _, c := range cases {
+		if actual := Newer(c.candidate, c.current); actual != c.expected {
+			t.Errorf("Newer(%s, %s): expected %t", c.candidate, c.current, c.expected)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package parsing
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+// The parts of a workflow file's structure that we care about, as read from its
+// YAML node tree.
+type Workflow struct {
+	Jobs []Job
+}
+
+// A single entry under the top-level `jobs` field. A job either runs its own
+// `Steps`, or calls a reusable workflow via `Uses`.
+type Job struct {
+	ID    string
+	Uses  *Uses
+	Steps []Step
+	// The images of its `container` and `services`, in file order.
+	Images []Uses
+}
+
+// A single entry under a job's `steps` field. Steps that only `run` commands
+// have no `Uses`.
+type Step struct {
+	Uses *Uses
+}
+
+// The value of a `uses` field (or of an image field), along with its position
+// in the original file.
+// `Line` and `Column` are both 1-based, and point to the first character of the
+// value itself, even if it was written within quotes.
+type Uses struct {
+	Value   string
+	Line    int
+	Column  int
+	Job     bool   // Found at the job level, and so refers to a reusable workflow.
+	Comment string // The text of a trailing comment on the same line, without its `#`.
+}
+
+// A precise substitution to be made within a workflow file. `Old` is expected to
+// be found exactly at the given position. If a `Comment` is given, it replaces
+// any trailing comment on that line, or is appended if there wasn't one.
+type Edit struct {
+	Line    int
+	Column  int
+	Old     string
+	New     string
+	Comment string
+}
+
+// Parse the contents of a workflow YAML file.
+func ParseWorkflow(file string) (*Workflow, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal([]byte(file), &root); err != nil {
+		return nil, err
+	}
+	w := Workflow{Jobs: make([]Job, 0)}
+	if root.Kind != yaml.DocumentNode || len(root.Content) == 0 {
+		return &w, nil
+	}
+	jobs := field(resolve(root.Content[0]), "jobs")
+	if jobs == nil || jobs.Kind != yaml.MappingNode {
+		return &w, nil
+	}
+	for i := 0; i+1 < len(jobs.Content); i += 2 {
+		w.Jobs = append(w.Jobs, parseJob(jobs.Content[i].Value, resolve(jobs.Content[i+1])))
+	}
+	return &w, nil
+}
+
+func parseJob(id string, node *yaml.Node) Job {
+	job := Job{ID: id, Uses: uses(node), Steps: make([]Step, 0), Images: make([]Uses, 0)}
+	if job.Uses != nil {
+		job.Uses.Job = true
+		if job.Uses.Comment == "" {
+			job.Uses.Comment = flowComment(job.Uses.Line, node)
+		}
+	}
+	if img := image(field(node, "container")); img != nil {
+		job.Images = append(job.Images, *img)
+	}
+	if services := field(node, "services"); services != nil && services.Kind == yaml.MappingNode {
+		for i := 1; i < len(services.Content); i += 2 {
+			if img := image(resolve(services.Content[i])); img != nil {
+				job.Images = append(job.Images, *img)
+			}
+		}
+	}
+	steps := field(node, "steps")
+	if steps == nil || steps.Kind != yaml.SequenceNode {
+		return job
+	}
+	perLine := make(map[int]int)
+	for _, s := range steps.Content {
+		step := Step{Uses: uses(resolve(s))}
+		if step.Uses != nil {
+			perLine[step.Uses.Line]++
+		}
+		job.Steps = append(job.Steps, step)
+	}
+	for i, s := range steps.Content {
+		// A line only has room for one comment, so it can't be told which of
+		// several steps on that line it was meant for.
+		if u := job.Steps[i].Uses; u != nil && u.Comment == "" && perLine[u.Line] == 1 {
+			u.Comment = flowComment(u.Line, resolve(s), steps)
+		}
+	}
+	return job
+}
+
+// The trailing comment of the line that a flow collection ends on, like:
+//
+//	steps: [{uses: actions/checkout@a81bbbf8298c0fa03ea29cdc473d45769f953675}] # v4.1.1
+//
+// yaml.v3 attaches such comments to the innermost collection that ends there,
+// rather than to the value that precedes them. The given nodes are checked in
+// order, and only if they end on the given line.
+func flowComment(line int, nodes ...*yaml.Node) string {
+	for _, n := range nodes {
+		if n == nil || n.Style&yaml.FlowStyle == 0 || lastLine(n) != line {
+			continue
+		}
+		if c := strings.TrimSpace(strings.TrimPrefix(n.LineComment, "#")); c != "" {
+			return c
+		}
+	}
+	return ""
+}
+
+// The line of the last value within a node.
+func lastLine(node *yaml.Node) int {
+	line := node.Line
+	for _, c := range node.Content {
+		if l := lastLine(c); l > line {
+			line = l
+		}
+	}
+	return line
+}
+
+// Yields nil if the given node has no scalar `uses` field.
+func uses(node *yaml.Node) *Uses {
+	return scalar(field(node, "uses"))
+}
+
+// The image of a `container` or service, which is either given directly, or
+// under an `image` field. Yields nil if there's none.
+func image(node *yaml.Node) *Uses {
+	if node != nil && node.Kind == yaml.MappingNode {
+		node = field(node, "image")
+	}
+	return scalar(node)
+}
+
+// The value and position of a scalar node. Yields nil for other nodes.
+func scalar(u *yaml.Node) *Uses {
+	if u == nil || u.Kind != yaml.ScalarNode {
+		return nil
+	}
+	column := u.Column
+	if u.Style&(yaml.SingleQuotedStyle|yaml.DoubleQuotedStyle) != 0 {
+		column++ // Skip the opening quote.
+	}
+	comment := strings.TrimSpace(strings.TrimPrefix(u.LineComment, "#"))
+	return &Uses{Value: u.Value, Line: u.Line, Column: column, Comment: comment}
+}
+
+// Look up the value of a key within a mapping node.
+func field(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return resolve(node.Content[i+1])
+		}
+	}
+	return nil
+}
+
+// Follow an alias to the node it refers to.
+func resolve(node *yaml.Node) *yaml.Node {
+	for node != nil && node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	return node
+}
+
+// Every image found in the workflow's jobs, in file order. Images run by steps
+// are given by their `uses` instead.
+func (w *Workflow) Images() []Uses {
+	us := make([]Uses, 0)
+	for _, job := range w.Jobs {
+		us = append(us, job.Images...)
+	}
+	return us
+}
+
+// Every `uses` value found in the workflow, in file order.
+func (w *Workflow) Uses() []Uses {
+	us := make([]Uses, 0)
+	for _, job := range w.Jobs {
+		if job.Uses != nil {
+			us = append(us, *job.Uses)
+		}
+		for _, step := range job.Steps {
+			if step.Uses != nil {
+				us = append(us, *step.Uses)
+			}
+		}
+	}
+	return us
+}
+
+// Apply some edits to the original contents of a workflow file. Edits whose
+// `Old` text isn't found at their stated position are ignored, as are
+// duplicates of the same position (which can occur through YAML aliases).
+func Apply(file string, edits []Edit) string {
+	sorted := make([]Edit, len(edits))
+	copy(sorted, edits)
+	// Work from the end of each line backwards, so that earlier edits on the
+	// same line don't shift the columns of later ones.
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Line != sorted[j].Line {
+			return sorted[i].Line < sorted[j].Line
+		}
+		return sorted[i].Column > sorted[j].Column
+	})
+
+	lines := strings.Split(file, "\n")
+	done := make(map[[2]int]bool)
+	for _, e := range sorted {
+		pos := [2]int{e.Line, e.Column}
+		if done[pos] || e.Line < 1 || e.Line > len(lines) {
+			continue
+		}
+		// Files with Windows line endings keep their `\r` until the end, so that
+		// comments don't land after it.
+		line := strings.TrimSuffix(lines[e.Line-1], "\r")
+		cr := line != lines[e.Line-1]
+		start := byteOffset(line, e.Column)
+		if start < 0 || !strings.HasPrefix(line[start:], e.Old) {
+			continue
+		}
+		rest := line[start+len(e.Old):]
+		if e.Comment != "" {
+			rest = withComment(rest, e.Comment)
+		}
+		lines[e.Line-1] = line[:start] + e.New + rest
+		if cr {
+			lines[e.Line-1] += "\r"
+		}
+		done[pos] = true
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Given the remainder of a line following some value, replace its trailing
+// comment with a new one.
+func withComment(rest string, comment string) string {
+	for i := 0; i < len(rest); i++ {
+		if rest[i] == '#' && (i == 0 || rest[i-1] == ' ' || rest[i-1] == '\t') {
+			return rest[:i] + "# " + comment
+		}
+	}
+	return strings.TrimRight(rest, " \t") + " # " + comment
+}
+
+// Convert a 1-based character column into a byte offset within the line.
+// Yields -1 if the column lies beyond the end of the line.
+func byteOffset(line string, column int) int {
+	offset := 0
+	for i := 1; i < column; i++ {
+		if offset >= len(line) {
+			return -1
+		}
+		_, size := utf8.DecodeRuneInString(line[offset:])
+		offset += size
+	}
+	if offset > len(line) {
+		return -1
+	}
+	return offset
+}
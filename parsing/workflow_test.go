@@ -0,0 +1,193 @@
+package parsing
+
+import "testing"
+
+const workflow = `name: CI
+on: [push]
+
+jobs:
+  build:
+    runs-on: ubuntu-latest
+    steps:
+    - name: Check out
+      uses: actions/checkout@v2  # Keep this comment.
+    - name: Build
+      run: 'echo uses: actions/checkout@v2'
+  test:
+    steps:
+    - uses: actions/cache@v1
+`
+
+func TestParseWorkflow(t *testing.T) {
+	w, err := ParseWorkflow(workflow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(w.Jobs) != 2 || w.Jobs[0].ID != "build" || w.Jobs[1].ID != "test" {
+		t.Fatalf("ParseWorkflow: unexpected jobs %v", w.Jobs)
+	}
+	if w.Jobs[0].Steps[1].Uses != nil {
+		t.Errorf("ParseWorkflow: a `run` step shouldn't have a `uses`")
+	}
+	uses := w.Uses()
+	expected := []Uses{{"actions/checkout@v2", 9, 13, false, "Keep this comment."}, {"actions/cache@v1", 14, 13, false, ""}}
+	if len(uses) != len(expected) {
+		t.Fatalf("Uses: expected %v, got %v", expected, uses)
+	}
+	for i, u := range uses {
+		if u != expected[i] {
+			t.Errorf("Uses: expected %v, got %v", expected[i], u)
+		}
+	}
+}
+
+func TestParseWorkflowInvalid(t *testing.T) {
+	if _, err := ParseWorkflow("jobs: [unclosed"); err == nil {
+		t.Errorf("ParseWorkflow: expected an error for malformed YAML")
+	}
+}
+
+func TestApply(t *testing.T) {
+	edits := []Edit{{9, 13, "actions/checkout@v2", "actions/checkout@v3", ""}}
+	result := Apply(workflow, edits)
+	expected := `name: CI
+on: [push]
+
+jobs:
+  build:
+    runs-on: ubuntu-latest
+    steps:
+    - name: Check out
+      uses: actions/checkout@v3  # Keep this comment.
+    - name: Build
+      run: 'echo uses: actions/checkout@v2'
+  test:
+    steps:
+    - uses: actions/cache@v1
+`
+	if result != expected {
+		t.Errorf("Apply: expected\n%s\ngot\n%s", expected, result)
+	}
+}
+
+func TestApplyMismatch(t *testing.T) {
+	edits := []Edit{{9, 12, "actions/checkout@v2", "actions/checkout@v3", ""}}
+	if result := Apply(workflow, edits); result != workflow {
+		t.Errorf("Apply: an edit at the wrong position should be ignored")
+	}
+}
+
+// Steps written as list items, in flow style, and with quoted values.
+const styles = `jobs:
+  build:
+    steps:
+    - uses: actions/checkout@v2
+    - {name: Cache, uses: actions/cache@v1, with: {path: ~/.cache}}
+    - uses: "actions/setup-go@v1"
+    - 'uses': 'actions/setup-node@v1'
+  lint:
+    steps: [{uses: actions/checkout@v2}, {uses: actions/cache@v1}]
+`
+
+func TestUsesStyles(t *testing.T) {
+	w, err := ParseWorkflow(styles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Uses{
+		{"actions/checkout@v2", 4, 13, false, ""},
+		{"actions/cache@v1", 5, 27, false, ""},
+		{"actions/setup-go@v1", 6, 14, false, ""},
+		{"actions/setup-node@v1", 7, 16, false, ""},
+		{"actions/checkout@v2", 9, 20, false, ""},
+		{"actions/cache@v1", 9, 49, false, ""}}
+	uses := w.Uses()
+	if len(uses) != len(expected) {
+		t.Fatalf("Uses: expected %v, got %v", expected, uses)
+	}
+	for i, u := range uses {
+		if u != expected[i] {
+			t.Errorf("Uses: expected %v, got %v", expected[i], u)
+		}
+	}
+}
+
+func TestApplyStyles(t *testing.T) {
+	w, _ := ParseWorkflow(styles)
+	edits := make([]Edit, 0)
+	for _, u := range w.Uses() {
+		edits = append(edits, Edit{u.Line, u.Column, u.Value, u.Value[:len(u.Value)-1] + "9", ""})
+	}
+	result := Apply(styles, edits)
+	expected := `jobs:
+  build:
+    steps:
+    - uses: actions/checkout@v9
+    - {name: Cache, uses: actions/cache@v9, with: {path: ~/.cache}}
+    - uses: "actions/setup-go@v9"
+    - 'uses': 'actions/setup-node@v9'
+  lint:
+    steps: [{uses: actions/checkout@v9}, {uses: actions/cache@v9}]
+`
+	if result != expected {
+		t.Errorf("Apply: expected\n%s\ngot\n%s", expected, result)
+	}
+}
+
+func TestReusableWorkflow(t *testing.T) {
+	w, err := ParseWorkflow("jobs:\n  call:\n    uses: org/repo/.github/workflows/build.yml@v1\n    with:\n      x: 1\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Uses{"org/repo/.github/workflows/build.yml@v1", 3, 11, true, ""}
+	if u := w.Jobs[0].Uses; u == nil || *u != expected {
+		t.Errorf("ParseWorkflow: expected %v, got %v", expected, u)
+	}
+}
+
+func TestApplyComment(t *testing.T) {
+	sha := "a81bbbf8298c0fa03ea29cdc473d45769f953675"
+	file := "- uses: actions/checkout@v2\n- uses: \"actions/cache@v1\"   # v1\n"
+	edits := []Edit{
+		{1, 9, "actions/checkout@v2", "actions/checkout@" + sha, "v4.1.1"},
+		{2, 10, "actions/cache@v1", "actions/cache@" + sha, "v3.0.0"}}
+	expected := "- uses: actions/checkout@" + sha + " # v4.1.1\n- uses: \"actions/cache@" + sha + "\"   # v3.0.0\n"
+	if result := Apply(file, edits); result != expected {
+		t.Errorf("Apply: expected\n%s\ngot\n%s", expected, result)
+	}
+}
+
+func TestApplyCRLF(t *testing.T) {
+	sha := "a81bbbf8298c0fa03ea29cdc473d45769f953675"
+	file := "jobs:\r\n  a:\r\n    steps:\r\n    - uses: actions/checkout@v3\r\n"
+	edits := []Edit{{4, 13, "actions/checkout@v3", "actions/checkout@" + sha, "v4.1.1"}}
+	expected := "jobs:\r\n  a:\r\n    steps:\r\n    - uses: actions/checkout@" + sha + " # v4.1.1\r\n"
+	if result := Apply(file, edits); result != expected {
+		t.Errorf("Apply: expected %q, got %q", expected, result)
+	}
+}
+
+func TestFlowComment(t *testing.T) {
+	sha := "a81bbbf8298c0fa03ea29cdc473d45769f953675"
+	file := "jobs:\n  a:\n    steps:\n    - {uses: actions/checkout@v3}\n  b:\n    steps: [{uses: actions/cache@v3}]\n  c:\n    steps: [{uses: a/b@v1}, {uses: c/d@v1}] # v2\n"
+	w, _ := ParseWorkflow(file)
+	edits := make([]Edit, 0)
+	for _, u := range w.Uses()[:2] {
+		edits = append(edits, Edit{u.Line, u.Column, u.Value, u.Value[:len(u.Value)-3] + "@" + sha, "v4.1.1"})
+	}
+	pinned, e0 := ParseWorkflow(Apply(file, edits))
+	if e0 != nil {
+		t.Fatal(e0)
+	}
+	uses := pinned.Uses()
+	for _, u := range uses[:2] {
+		if u.Comment != "v4.1.1" {
+			t.Errorf("Uses: expected the flow collection's comment for %s, got %q", u.Value, u.Comment)
+		}
+	}
+	for _, u := range uses[2:] {
+		if u.Comment != "" {
+			t.Errorf("Uses: a comment shared by several steps shouldn't be given to %s", u.Value)
+		}
+	}
+}
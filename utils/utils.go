@@ -3,6 +3,7 @@ package utils
 import (
 	"fmt"
 	"os"
+	"sync"
 )
 
 // Exit the program with an appropriate status code if our `error` value was
@@ -18,3 +19,23 @@ func PrintExit(msg string) {
 	fmt.Println(msg)
 	os.Exit(1)
 }
+
+// Limits how many tasks may run at once. A task must never wait on other tasks
+// of the same `Pool`, lest every slot be taken by waiting tasks.
+type Pool chan struct{}
+
+func NewPool(size int) Pool {
+	return make(Pool, size)
+}
+
+// Run `f` in its own goroutine as soon as a slot is free, blocking until then.
+// Tasks therefore start in the order they're given.
+func (p Pool) Go(wg *sync.WaitGroup, f func()) {
+	p <- struct{}{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer func() { <-p }()
+		f()
+	}()
+}